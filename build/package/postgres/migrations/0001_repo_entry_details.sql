@@ -0,0 +1,7 @@
+-- store repository owner and name alongside repo entries. both are
+-- required to manage git hooks once the entry has been registered
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS repo_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS repo_owner TEXT NOT NULL DEFAULT '';
+
+-- backfill existing entries from github URLs of the form https://github.com/<owner>/<repo>
+UPDATE repo_entries SET repo_owner = split_part(repo_url, '/', 4), repo_name = split_part(repo_url, '/', 5) WHERE repo_name = '';
@@ -0,0 +1,6 @@
+-- indexes used for keyset pagination and filtering of list endpoints
+CREATE INDEX IF NOT EXISTS repo_entries_created_at_idx ON repo_entries(created_at, entry_id);
+CREATE INDEX IF NOT EXISTS repo_entries_repo_url_idx ON repo_entries(repo_url, entry_id);
+CREATE INDEX IF NOT EXISTS repo_entries_uid_idx ON repo_entries(uid);
+CREATE INDEX IF NOT EXISTS git_hooks_created_at_idx ON git_hooks(created_at, hook_id);
+CREATE INDEX IF NOT EXISTS git_hooks_entry_id_idx ON git_hooks(entry_id);
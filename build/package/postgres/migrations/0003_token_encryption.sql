@@ -0,0 +1,4 @@
+-- store redacted fingerprints of access tokens alongside encrypted tokens.
+-- existing plaintext tokens are encrypted by running cmd/reencrypt
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS token_fingerprint TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS token_updated_at TIMESTAMP NOT NULL DEFAULT NOW();
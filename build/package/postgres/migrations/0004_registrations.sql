@@ -0,0 +1,12 @@
+-- store state of repo registrations so that failed registrations can be queried
+CREATE TABLE IF NOT EXISTS registrations (
+    registration_id UUID PRIMARY KEY,
+    entry_id UUID,
+    uid TEXT NOT NULL,
+    repo_url TEXT NOT NULL,
+    state TEXT NOT NULL,
+    step TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+-- store branch (or glob pattern of branches) that deployments are triggered by
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS tracked_branch TEXT NOT NULL DEFAULT 'master';
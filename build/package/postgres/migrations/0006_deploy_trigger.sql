@@ -0,0 +1,4 @@
+-- store mode that deployments are triggered by (branch, tag or release)
+-- and the pattern of tags that deployments are triggered for
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS deploy_trigger TEXT NOT NULL DEFAULT 'branch';
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS tag_pattern TEXT NOT NULL DEFAULT '*';
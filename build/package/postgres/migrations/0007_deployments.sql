@@ -0,0 +1,15 @@
+-- store history of deployments triggered for repo entries
+CREATE TABLE IF NOT EXISTS deployments (
+    deployment_id UUID PRIMARY KEY,
+    entry_id UUID NOT NULL REFERENCES repo_entries(entry_id) ON DELETE CASCADE,
+    commit_sha TEXT NOT NULL DEFAULT '',
+    ref TEXT NOT NULL DEFAULT '',
+    trigger TEXT NOT NULL,
+    state TEXT NOT NULL,
+    triggered_by TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS deployments_entry_id_idx ON deployments(entry_id, created_at, deployment_id);
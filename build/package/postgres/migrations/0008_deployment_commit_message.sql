@@ -0,0 +1,2 @@
+-- store message of the head commit that deployments were triggered by
+ALTER TABLE deployments ADD COLUMN IF NOT EXISTS commit_message TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- store type of credentials used by the daemon to clone repos (none, token or deploy_key)
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS credential_type TEXT NOT NULL DEFAULT 'token';
+
+-- store read-only deploy keys generated for repo entries. private keys
+-- are encrypted with the token encryption keyring
+CREATE TABLE IF NOT EXISTS deploy_keys (
+    entry_id UUID PRIMARY KEY REFERENCES repo_entries(entry_id) ON DELETE CASCADE,
+    git_key_id BIGINT NOT NULL,
+    public_key TEXT NOT NULL,
+    private_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
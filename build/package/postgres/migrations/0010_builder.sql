@@ -0,0 +1,3 @@
+-- store build strategy used by the daemon to deploy repo entries. entries
+-- with the auto builder are built with the builder detected from the repo
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS builder TEXT NOT NULL DEFAULT 'auto';
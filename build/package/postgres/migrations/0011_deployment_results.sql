@@ -0,0 +1,4 @@
+-- store results of deployments reported by the daemon
+ALTER TABLE deployments ADD COLUMN IF NOT EXISTS failed_step TEXT NOT NULL DEFAULT '';
+ALTER TABLE deployments ADD COLUMN IF NOT EXISTS duration_ms BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE deployments ADD COLUMN IF NOT EXISTS container_ids TEXT[] NOT NULL DEFAULT '{}';
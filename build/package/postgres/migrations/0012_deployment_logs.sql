@@ -0,0 +1,10 @@
+-- store build logs shipped by the daemon. lines are numbered per deployment
+-- so that redelivered batches are only stored once
+CREATE TABLE IF NOT EXISTS deployment_logs (
+    deployment_id UUID NOT NULL REFERENCES deployments(deployment_id) ON DELETE CASCADE,
+    sequence BIGINT NOT NULL,
+    stream TEXT NOT NULL,
+    line TEXT NOT NULL,
+    logged_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (deployment_id, sequence)
+);
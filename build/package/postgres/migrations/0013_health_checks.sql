@@ -0,0 +1,6 @@
+-- store health checks run by the daemon after deployments. the daemon
+-- default timeout is used if the timeout is 0, and the previous release
+-- is restored automatically if auto_rollback is set and the check fails
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS health_check_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS health_check_timeout INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS auto_rollback BOOLEAN NOT NULL DEFAULT FALSE;
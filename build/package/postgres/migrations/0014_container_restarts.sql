@@ -0,0 +1,13 @@
+-- store restarts of crashed containers reported by the daemon
+CREATE TABLE IF NOT EXISTS container_restarts (
+    restart_id UUID PRIMARY KEY,
+    entry_id UUID NOT NULL REFERENCES repo_entries(entry_id) ON DELETE CASCADE,
+    container_id TEXT NOT NULL,
+    container_name TEXT NOT NULL DEFAULT '',
+    exit_code INTEGER NOT NULL DEFAULT 0,
+    attempt INTEGER NOT NULL DEFAULT 0,
+    initiator TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS container_restarts_entry_id_idx ON container_restarts(entry_id, created_at, restart_id);
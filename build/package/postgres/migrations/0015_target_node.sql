@@ -0,0 +1,3 @@
+-- store node of the daemon that repo entries are deployed on. entries
+-- without a target node are deployed by all daemons
+ALTER TABLE repo_entries ADD COLUMN IF NOT EXISTS target_node TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+-- store events that could not be processed after all delivery attempts.
+-- dead letters are identified by the message ID and the queue that they
+-- were dead-lettered from, so redelivered dead letters are only stored once
+CREATE TABLE IF NOT EXISTS dead_letters (
+    dead_letter_id UUID PRIMARY KEY,
+    event_id UUID,
+    event_type TEXT NOT NULL DEFAULT '',
+    entry_id UUID,
+    queue TEXT NOT NULL,
+    exchange TEXT NOT NULL DEFAULT '',
+    routing_key TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS dead_letters_created_at_idx ON dead_letters(created_at, dead_letter_id);
@@ -3,27 +3,38 @@ package main
 import (
     "fmt"
     "os"
+    "context"
+    "syscall"
     "os/signal"
     "github.com/PSauerborn/go-get-git/pkg/daemon"
     log "github.com/sirupsen/logrus"
 )
 
 func main() {
-    // create channel used for signal catching
+    ctx, cancel := context.WithCancel(context.Background())
+    // create channel used for signal catching. only SIGTERM and SIGINT
+    // shut the daemon down, while SIGHUP is ignored so that closing the
+    // controlling terminal does not kill running deployments
     sigs := make(chan os.Signal, 1)
-    signal.Notify(sigs)
+    signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+    signal.Ignore(syscall.SIGHUP)
 
     go func() {
         s := <-sigs
         log.Info(fmt.Sprintf("received signal %s", s))
         PostServiceHook()
-        os.Exit(1)
+        cancel()
+        for s := range(sigs) {
+            log.Info(fmt.Sprintf("received signal %s while shutting down. waiting for running jobs", s))
+        }
     }()
-    // create new service and start listening on rabbit queue
+    // create new service and start listening on rabbit queue until a
+    // shutdown signal is received
     service := daemon.New()
-    service.Run()
+    service.Run(ctx)
+    log.Info("go-get-git daemon stopped")
 }
 
 func PostServiceHook() {
     log.Info("shutting down go-get-git deamon...")
-}
\ No newline at end of file
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+    "github.com/PSauerborn/go-get-git/pkg/api"
+)
+
+// command used to re-encrypt stored access tokens after key rotation
+func main() {
+    api.ReEncryptTokens()
+}
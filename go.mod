@@ -4,12 +4,14 @@ go 1.14
 
 require (
 	github.com/PSauerborn/go-get-git/api v0.0.0-20200910114729-3f3e3028cf45
-	github.com/PSauerborn/go-jackrabbit v0.0.0-20200913150453-e06427e1d566
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/google/uuid v1.1.2
+	github.com/jackc/pgconn v1.6.4
 	github.com/jackc/pgx/v4 v4.8.1
 	github.com/sirupsen/logrus v1.6.0
 	github.com/streadway/amqp v1.0.0
+	go.etcd.io/bbolt v1.3.5
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -2,6 +2,10 @@ package api
 
 import (
     "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "crypto/subtle"
     "github.com/gin-gonic/gin"
     "github.com/google/go-github/github"
     "github.com/google/uuid"
@@ -18,16 +22,33 @@ func New() *GoGetGitAPI {
 
     // configure GET routes used for server
     service.router.GET("/go-get-git/health", service.HealthCheck)
-    service.router.GET("/go-get-git/registry", service.GetRegistryEntries)
-    service.router.GET("/go-get-git/registry/:entryId", service.GetRegistryEntry)
-    service.router.GET("/go-get-git/hooks", service.GetHookEntries)
-    service.router.GET("/go-get-git/hooks/:entryId", service.GetHookEntriesById)
-    service.router.GET("/go-get-git/hook/:hookId", service.GetHookEntry)
+    service.router.GET("/go-get-git/registry", requireUser, service.GetRegistryEntries)
+    service.router.GET("/go-get-git/registry/:entryId", requireUser, service.GetRegistryEntry)
+    service.router.GET("/go-get-git/hooks", requireUser, service.GetHookEntries)
+    service.router.GET("/go-get-git/hooks/:entryId", requireUser, service.GetHookEntriesById)
+    service.router.GET("/go-get-git/hook/:hookId", requireUser, service.GetHookEntry)
+    service.router.GET("/go-get-git/registrations/:registrationId", requireUser, service.GetRegistration)
+    service.router.GET("/go-get-git/registry/:entryId/deployments", requireUser, service.GetDeployments)
+    service.router.GET("/go-get-git/registry/:entryId/restarts", requireUser, service.GetContainerRestarts)
+    service.router.GET("/go-get-git/deployments/:deploymentId", requireUser, service.GetDeployment)
+    service.router.GET("/go-get-git/deployments/:deploymentId/logs", requireUser, service.GetDeploymentLogs)
+    // configure internal routes used by daemon
+    service.router.GET("/go-get-git/internal/credentials/:entryId", requireDaemon, service.GetEntryCredentials)
+    // configure admin routes used to manage dead-lettered events
+    service.router.GET("/go-get-git/admin/dead-letters", requireAdmin, service.GetDeadLetters)
+    service.router.GET("/go-get-git/admin/dead-letters/:deadLetterId", requireAdmin, service.GetDeadLetter)
+    service.router.POST("/go-get-git/admin/dead-letters/:deadLetterId/requeue", requireAdmin, service.RequeueDeadLetter)
+    service.router.DELETE("/go-get-git/admin/dead-letters", requireAdmin, service.PurgeDeadLetters)
+    service.router.DELETE("/go-get-git/admin/dead-letters/:deadLetterId", requireAdmin, service.RemoveDeadLetter)
     // configure POST routes used for server
-    service.router.POST("/go-get-git/registry", service.CreateRegistryEntry)
+    service.router.POST("/go-get-git/registry", requireUser, service.CreateRegistryEntry)
     service.router.POST("/go-get-git/webhook", service.HandleGitWebHook)
+    service.router.POST("/go-get-git/registry/:entryId/deploy", requireUser, service.DeployRegistryEntry)
+    service.router.POST("/go-get-git/registry/:entryId/rollback", requireUser, service.RollbackRegistryEntry)
+    // configure PATCH routes used for server
+    service.router.PATCH("/go-get-git/registry/:entryId", requireUser, service.UpdateRegistryEntry)
     // configure DELETE routes used for server
-    service.router.DELETE("/go-get-git/registry/:entryId", service.RemoveRegistryEntry)
+    service.router.DELETE("/go-get-git/registry/:entryId", requireUser, service.RemoveRegistryEntry)
 
     return &service
 }
@@ -36,6 +57,93 @@ func getUser(ctx *gin.Context) string {
     return ctx.Request.Header.Get("X-Authenticated-Userid")
 }
 
+// function used to determine if user has the configured admin role.
+// roles are read from the role header as a space or comma separated list
+func isAdmin(ctx *gin.Context) bool {
+    roles := strings.FieldsFunc(ctx.Request.Header.Get(RoleHeader), func(c rune) bool {
+        return c == ' ' || c == ','
+    })
+    for _, role := range(roles) {
+        if role == AdminRole {
+            return true
+        }
+    }
+    return false
+}
+
+// middleware used to reject requests that do not contain a user
+func requireUser(ctx *gin.Context) {
+    if len(getUser(ctx)) == 0 {
+        log.Error("received request without authenticated user")
+        StandardHTTP.Unauthorized(ctx)
+        return
+    }
+    ctx.Next()
+}
+
+// middleware used to reject requests from users without the admin role
+func requireAdmin(ctx *gin.Context) {
+    if len(getUser(ctx)) == 0 || !isAdmin(ctx) {
+        log.Error(fmt.Sprintf("received admin request from user '%s' without admin role", getUser(ctx)))
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+    ctx.Next()
+}
+
+// middleware used to reject requests that do not contain the shared
+// daemon secret. Note that internal routes are disabled if no secret is set
+func requireDaemon(ctx *gin.Context) {
+    secret := ctx.Request.Header.Get(DaemonSecretHeader)
+    if len(DaemonSecret) == 0 || subtle.ConstantTimeCompare([]byte(secret), []byte(DaemonSecret)) != 1 {
+        log.Error("received internal request with invalid daemon secret")
+        StandardHTTP.Forbidden(ctx)
+        return
+    }
+    ctx.Next()
+}
+
+// function used to parse list options from request. Note that non-admin
+// users can only list their own entries. The HTTP response is written
+// and false is returned if the list options are invalid
+func getListOptions(ctx *gin.Context) (ListOptions, bool) {
+    options, err := parseListOptions(ctx)
+    if err != nil {
+        log.Error(fmt.Errorf("received invalid list options: %v", err))
+        StandardHTTP.InvalidRequest(ctx)
+        return options, false
+    }
+    if !isAdmin(ctx) {
+        options.Uid = getUser(ctx)
+    }
+    return options, true
+}
+
+// function used to retrieve repo entry and check that the requesting
+// user owns the entry. Note that entries owned by other users are
+// reported as not found to avoid leaking entry IDs. The HTTP response
+// is written and false is returned if the entry cannot be accessed
+func getAuthorizedEntry(ctx *gin.Context, entryId uuid.UUID) (GitRepoEntry, bool) {
+    entry, err := persistence.getRepoEntry(entryId)
+    if err != nil {
+        switch err {
+            // return 404 if no data entry exists
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+            // by default, return internal server error
+        default:
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return entry, false
+    }
+    if entry.Uid != getUser(ctx) && !isAdmin(ctx) {
+        log.Warn(fmt.Sprintf("user %s attempted to access entry %s owned by %s", getUser(ctx), entryId, entry.Uid))
+        StandardHTTP.NotFound(ctx)
+        return entry, false
+    }
+    return entry, true
+}
+
 type GoGetGitAPI struct {
     router *gin.Engine
 }
@@ -43,13 +151,37 @@ type GoGetGitAPI struct {
 func(api GoGetGitAPI) Run() {
     // configure environment variables and connect persistence
     ConfigureService()
+    LoadKeyring()
     ConnectPersistence()
+    // listen for build events sent by daemon in background
+    go ListenForEvents()
+    go ListenForDeadLetters()
 
     connection := fmt.Sprintf("%s:%d", ListenAddress, ListenPort)
     log.Info(fmt.Sprintf("starting new go-get-git service at %s", connection))
     api.router.Run(connection)
 }
 
+// function used to re-encrypt all stored access tokens and deploy keys
+// with the primary token encryption key. used after token keys are rotated
+func ReEncryptTokens() {
+    ConfigureService()
+    LoadKeyring()
+    ConnectPersistence()
+
+    count, err := persistence.reencryptTokens()
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to re-encrypt access tokens: %v", err))
+    }
+    log.Info(fmt.Sprintf("successfully re-encrypted %d access token(s)", count))
+
+    count, err = persistence.reencryptDeployKeys()
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to re-encrypt deploy keys: %v", err))
+    }
+    log.Info(fmt.Sprintf("successfully re-encrypted %d deploy key(s)", count))
+}
+
 // function used as basic health check
 func(api GoGetGitAPI) HealthCheck(ctx *gin.Context) {
     StandardHTTP.Success(ctx)
@@ -65,90 +197,322 @@ func(api GoGetGitAPI) CreateRegistryEntry(ctx *gin.Context) {
         StandardHTTP.InvalidRequestBody(ctx)
         return
     }
-    log.Debug(fmt.Sprintf("processing request with body %+v", requestBody))
-    // create new repo entry in database
-    entryId, err := persistence.createRepoEntry(getUser(ctx), requestBody)
-    if err != nil {
-        log.Error(fmt.Sprintf("received invalid request body"))
-        StandardHTTP.InternalServerError(ctx)
-        return
+    // default to deployments on pushes to master branch if no deploy settings are given
+    if len(requestBody.TrackedBranch) == 0 {
+        requestBody.TrackedBranch = DefaultTrackedBranch
     }
-    // create new applications and process event
-    err = processNewApplicationEvent(ctx, entryId, getUser(ctx), requestBody.RepoName, requestBody.RepoUrl)
-    if err != nil {
-        log.Error(fmt.Errorf("unable to process new application: %v", err))
-        StandardHTTP.InternalServerError(ctx)
+    if len(requestBody.DeployTrigger) == 0 {
+        requestBody.DeployTrigger = DeployOnBranch
+    }
+    if len(requestBody.TagPattern) == 0 {
+        requestBody.TagPattern = DefaultTagPattern
+    }
+    if len(requestBody.CredentialType) == 0 {
+        requestBody.CredentialType = CredentialToken
+    }
+    if len(requestBody.Builder) == 0 {
+        requestBody.Builder = BuilderAuto
+    }
+    if !isValidRefPattern(requestBody.TrackedBranch) || !isValidRefPattern(requestBody.TagPattern) {
+        log.Error(fmt.Sprintf("received invalid tracked branch %s or tag pattern %s", requestBody.TrackedBranch, requestBody.TagPattern))
+        StandardHTTP.InvalidRequestBody(ctx)
         return
     }
-    // create new git hook on git server
-    body, err := createGitWebHook(requestBody.RepoOwner, requestBody.RepoName, requestBody.RepoAccessToken)
-    if err != nil {
-        log.Error(fmt.Errorf("unable to create git hook: %v", err))
-        StandardHTTP.InvalidRequest(ctx)
+    if !isValidTargetNode(requestBody.TargetNode) {
+        log.Error(fmt.Sprintf("received invalid target node %s", requestBody.TargetNode))
+        StandardHTTP.InvalidRequestBody(ctx)
         return
     }
-    // create new hook entry in database
-    _, err = persistence.createHookEntry(entryId, body)
+    log.Debug(fmt.Sprintf("processing request with body %+v", requestBody.redacted()))
+    // register repo. all steps are rolled back if any step fails
+    registration, err := registerRepoEntry(getUser(ctx), requestBody)
     if err != nil {
-        log.Error(fmt.Sprintf("received invalid request body"))
-        StandardHTTP.InternalServerError(ctx)
+        log.Error(fmt.Errorf("unable to register repo: %v", err))
+        code := 500
+        if registration.Step == "create_git_hook" || registration.Step == "create_deploy_key" {
+            code = 400
+        }
+        ctx.AbortWithStatusJSON(code, gin.H{"http_code": code, "success": false, "message": "unable to register repo", "payload": registration})
         return
     }
-    response := gin.H{"http_code": 200, "success": true, "message": "successfully registered new repo"}
+    response := gin.H{"http_code": 200, "success": true, "message": "successfully registered new repo", "payload": registration}
     ctx.JSON(200, response)
 }
 
-// API Handler used to get specific registry entry
-func(api GoGetGitAPI) GetRegistryEntry(ctx *gin.Context) {
-    entryId, err := uuid.Parse(ctx.Param("entryId"))
+// API Handler used to retrieve state of a repo registration
+func(api GoGetGitAPI) GetRegistration(ctx *gin.Context) {
+    registrationId, err := uuid.Parse(ctx.Param("registrationId"))
     if err != nil {
-        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("registrationId")))
         StandardHTTP.InvalidRequest(ctx)
         return
     }
-    // get repo entry from database
-    entry, err := persistence.getRepoEntry(entryId)
+    registration, err := persistence.getRegistration(registrationId)
     if err != nil {
         switch err {
-            // return 404 if no data entry exists
         case pgx.ErrNoRows:
             StandardHTTP.NotFound(ctx)
             return
-            // by default, return internal server error
         default:
             StandardHTTP.InternalServerError(ctx)
             return
         }
     }
+    if registration.Uid != getUser(ctx) && !isAdmin(ctx) {
+        StandardHTTP.NotFound(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": registration})
+}
+
+// API Handler used to get specific registry entry
+func(api GoGetGitAPI) GetRegistryEntry(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    // get repo entry from database
+    entry, ok := getAuthorizedEntry(ctx, entryId)
+    if !ok {
+        return
+    }
     ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": entry})
 }
 
-// API Hander used to get user registry entries
+// API Hander used to get user registry entries. Results are paginated
+// and can be filtered and sorted with query parameters. Note that
+// entries are restricted to the requesting user unless user is admin
 func(api GoGetGitAPI) GetRegistryEntries(ctx *gin.Context) {
     log.Debug(fmt.Sprintf("received request for registry entries from user %s", getUser(ctx)))
+    options, ok := getListOptions(ctx)
+    if !ok {
+        return
+    }
     // retrieve repo entries from database
-    entries, err := persistence.getAllRepoEntries()
+    entries, cursor, err := persistence.getAllRepoEntries(options)
     if err != nil {
-        switch err {
-        case pgx.ErrNoRows:
-            ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": []GitRepoEntry{}})
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    listResponse(ctx, entries, cursor)
+}
+
+// API Handler used to update registry entry in place. Git hooks are
+// re-created if the repo owner, name or access token are changed, deploy
+// keys are re-created if the repo or credential type are changed, and
+// the application directory is moved if the repo name is changed
+func(api GoGetGitAPI) UpdateRegistryEntry(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    var requestBody UpdateRegistryEntry
+    if err := ctx.ShouldBindJSON(&requestBody); err != nil {
+        log.Error(fmt.Sprintf("received invalid request body"))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to update registry entry %s with body %+v", entryId, requestBody))
+    previous, ok := getAuthorizedEntry(ctx, entryId)
+    if !ok {
+        return
+    }
+
+    // apply partial update to entry
+    entry := previous
+    if requestBody.RepoName != nil {
+        entry.RepoName = *requestBody.RepoName
+    }
+    if requestBody.RepoUrl != nil {
+        entry.RepoUrl = *requestBody.RepoUrl
+    }
+    if requestBody.RepoOwner != nil {
+        entry.RepoOwner = *requestBody.RepoOwner
+    }
+    if requestBody.RepoAccessToken != nil {
+        entry.AccessToken = *requestBody.RepoAccessToken
+    }
+    if requestBody.TrackedBranch != nil {
+        entry.TrackedBranch = *requestBody.TrackedBranch
+    }
+    if requestBody.DeployTrigger != nil {
+        entry.DeployTrigger = *requestBody.DeployTrigger
+    }
+    if requestBody.TagPattern != nil {
+        entry.TagPattern = *requestBody.TagPattern
+    }
+    if requestBody.CredentialType != nil {
+        entry.CredentialType = *requestBody.CredentialType
+    }
+    if requestBody.Builder != nil {
+        entry.Builder = *requestBody.Builder
+    }
+    if requestBody.HealthCheckUrl != nil {
+        entry.HealthCheckUrl = *requestBody.HealthCheckUrl
+    }
+    if requestBody.HealthCheckTimeout != nil {
+        entry.HealthCheckTimeout = *requestBody.HealthCheckTimeout
+    }
+    if requestBody.AutoRollback != nil {
+        entry.AutoRollback = *requestBody.AutoRollback
+    }
+    if requestBody.TargetNode != nil {
+        entry.TargetNode = *requestBody.TargetNode
+    }
+    if !isValidRefPattern(entry.TrackedBranch) || !isValidRefPattern(entry.TagPattern) {
+        log.Error(fmt.Sprintf("received invalid tracked branch %s or tag pattern %s", entry.TrackedBranch, entry.TagPattern))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    if !isValidTargetNode(entry.TargetNode) {
+        log.Error(fmt.Sprintf("received invalid target node %s", entry.TargetNode))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+
+    // update entry in a single transaction. changes made on the git server
+    // are compensated if the update fails, while previous git hooks and
+    // deploy keys are only removed once the update has been committed
+    s, cleanups, invalid := saga{}, []func(){}, false
+    err = persistence.withTransaction(func(tx Persistence) error {
+        // re-create git hooks if any of the git hook settings have changed. hooks
+        // are also re-created if the deploy trigger has changed, since hooks
+        // created before tags and releases were deployed only receive pushes
+        if entry.DeployTrigger != previous.DeployTrigger || entry.RepoOwner != previous.RepoOwner || entry.RepoName != previous.RepoName || entry.AccessToken != previous.AccessToken {
+            cleanup, err := resyncEntryWebHooks(tx, &s, previous, entry)
+            if err != nil {
+                log.Error(fmt.Errorf("unable to re-sync git hooks for entry %s: %v", entryId, err))
+                invalid = true
+                return err
+            }
+            cleanups = append(cleanups, cleanup)
+        }
+        // re-create deploy keys if credential type or repo have changed
+        hasDeployKey := entry.CredentialType == CredentialDeployKey || previous.CredentialType == CredentialDeployKey
+        if hasDeployKey && (entry.CredentialType != previous.CredentialType || entry.RepoOwner != previous.RepoOwner || entry.RepoName != previous.RepoName) {
+            cleanup, err := resyncEntryDeployKey(tx, &s, previous, entry)
+            if err != nil {
+                log.Error(fmt.Errorf("unable to re-sync deploy key for entry %s: %v", entryId, err))
+                invalid = true
+                return err
+            }
+            cleanups = append(cleanups, cleanup)
+        }
+        return tx.updateRepoEntry(entry)
+    })
+    if err != nil {
+        log.Error(fmt.Errorf("unable to update entry %s: %v. rolling back changes", entryId, err))
+        s.compensate()
+        if invalid {
+            StandardHTTP.InvalidRequest(ctx)
+        } else {
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    for _, cleanup := range(cleanups) {
+        cleanup()
+    }
+    // move application to new node if target node has changed, else
+    // move application directory if repo has been renamed
+    if entry.TargetNode != previous.TargetNode {
+        if err := processRelocateApplicationEvent(ctx, previous, entry); err != nil {
+            log.Error(fmt.Errorf("unable to move application to node %s: %v", entry.TargetNode, err))
+            StandardHTTP.InternalServerError(ctx)
             return
-        default:
+        }
+    } else if entry.RepoName != previous.RepoName {
+        if err := processMoveApplicationEvent(ctx, entry); err != nil {
+            log.Error(fmt.Errorf("unable to process application move: %v", err))
             StandardHTTP.InternalServerError(ctx)
             return
         }
     }
-    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": entries})
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": entry})
 }
 
-// API Handler used to remove registry entry
+// API Handler used to remove registry entry. The git hooks and deploy
+// keys registered on the git server are removed along with the database
+// entries, and the daemon is notified to tear down the application. The
+// daemon is notified before the database entries are removed, so that
+// failed removals can be retried. Note that failures to remove git hooks
+// or keys can be ignored by setting force=true
 func(api GoGetGitAPI) RemoveRegistryEntry(ctx *gin.Context) {
-    StandardHTTP.FeatureNotSupported(ctx)
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to remove registry entry %s from user %s", entryId, getUser(ctx)))
+    // get repo entry from database
+    entry, ok := getAuthorizedEntry(ctx, entryId)
+    if !ok {
+        return
+    }
+    hooks, _, err := persistence.getAllHookEntriesByEntryId(entryId, ListOptions{})
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    // remove git hooks and deploy keys from git server before removing database entries
+    if err := deleteEntryWebHooks(entry, hooks); err != nil {
+        log.Error(fmt.Errorf("unable to remove git hooks for entry %s: %v", entryId, err))
+        if ctx.Query("force") != "true" {
+            abortGitRequest(ctx, err)
+            return
+        }
+    }
+    if err := deleteEntryDeployKey(entry); err != nil {
+        log.Error(fmt.Errorf("unable to remove deploy key for entry %s: %v", entryId, err))
+        if ctx.Query("force") != "true" {
+            abortGitRequest(ctx, err)
+            return
+        }
+    }
+    // get application directory. entries without directories are still removed
+    directory, err := persistence.getEntryDirectory(entryId)
+    if err != nil && err != pgx.ErrNoRows {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+
+    // notify daemon that application should be torn down
+    if len(directory) > 0 {
+        if err := processRemoveApplicationEvent(ctx, entry, directory); err != nil {
+            log.Error(fmt.Errorf("unable to process application removal: %v", err))
+            StandardHTTP.InternalServerError(ctx)
+            return
+        }
+    }
+    if err := persistence.removeRepoEntry(entryId); err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    response := gin.H{"http_code": 200, "success": true, "message": "successfully removed repo"}
+    ctx.JSON(200, response)
 }
 
-// API route used to handle git hooks. Note that only Git Hooks
-// that contain pushes to the master repositrory are handled and
-// sent over the message bus
+// helper function used to abort requests that failed due to errors
+// returned by the git server. invalid requests to the git server are
+// returned as invalid requests, while unavailable git servers are
+// returned as bad gateway errors
+func abortGitRequest(ctx *gin.Context, err error) {
+    if isGitClientError(err) {
+        StandardHTTP.InvalidRequest(ctx)
+    } else {
+        StandardHTTP.BadGateway(ctx)
+    }
+}
+
+// API route used to handle git hooks. Note that only Git Hooks that
+// match the deploy trigger of a repo (pushes to the tracked branch,
+// new tags or published releases) are handled and sent over the
+// message bus
 func(api GoGetGitAPI) HandleGitWebHook(ctx *gin.Context) {
     log.Info("received new git hook trigger")
     // validate git hook request
@@ -167,14 +531,15 @@ func(api GoGetGitAPI) HandleGitWebHook(ctx *gin.Context) {
     }
     log.Info(fmt.Sprintf("received event hook %+v", event))
 
-    // check event type matches Push Event, and then check that push referse to master branch
+    // check event type matches Push, Create or Release events. events are
+    // filtered by the deploy trigger of the repo entry when processed
     switch e := event.(type) {
     case *github.PushEvent:
-        if isMasterPushEvent(e) {
-            processGitPushEvent(ctx, e)
-        } else {
-            log.Info(fmt.Sprintf("received push event to non-master branch %s", *e.Ref))
-        }
+        processGitPushEvent(ctx, e)
+    case *github.CreateEvent:
+        processGitCreateEvent(ctx, e)
+    case *github.ReleaseEvent:
+        processGitReleaseEvent(ctx, e)
     default:
         log.Info(fmt.Sprintf("received non-push type event %v", e))
     }
@@ -203,25 +568,28 @@ func(api GoGetGitAPI) GetHookEntry(ctx *gin.Context) {
             return
         }
     }
+    // check that user owns the repo entry that the hook belongs to
+    if _, ok := getAuthorizedEntry(ctx, entry.EntryId); !ok {
+        return
+    }
     ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": entry})
 }
 
-// API Route used to retrieve all hook entries currently stored in database
+// API Route used to retrieve all hook entries currently stored in database.
+// Results are paginated and restricted to the requesting user unless user is admin
 func(api GoGetGitAPI) GetHookEntries(ctx *gin.Context) {
     log.Debug(fmt.Sprintf("received request to fetch all hook entries from user %s", getUser(ctx)))
+    options, ok := getListOptions(ctx)
+    if !ok {
+        return
+    }
     // retrieve list of hook entries from database and return
-    entries, err := persistence.getAllHookEntries()
+    entries, cursor, err := persistence.getAllHookEntries(options)
     if err != nil {
-        switch err {
-        case pgx.ErrNoRows:
-            ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": []GitHookEntry{}})
-            return
-        default:
-            StandardHTTP.InternalServerError(ctx)
-            return
-        }
+        StandardHTTP.InternalServerError(ctx)
+        return
     }
-    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": entries})
+    listResponse(ctx, entries, cursor)
 }
 
 // API route used to retrieve all git hook entries that belong
@@ -235,16 +603,345 @@ func(api GoGetGitAPI) GetHookEntriesById(ctx *gin.Context) {
     }
 
     log.Debug(fmt.Sprintf("received request for hook entries for entry ID %s", entryId))
-    entries, err := persistence.getAllHookEntriesByEntryId(entryId)
+    if _, ok := getAuthorizedEntry(ctx, entryId); !ok {
+        return
+    }
+    options, ok := getListOptions(ctx)
+    if !ok {
+        return
+    }
+    entries, cursor, err := persistence.getAllHookEntriesByEntryId(entryId, options)
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    listResponse(ctx, entries, cursor)
+}
+
+// API route used to retrieve deployments of a particular repo entry.
+// Results are paginated and sorted by creation date by default
+func(api GoGetGitAPI) GetDeployments(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request for deployments for entry ID %s", entryId))
+    if _, ok := getAuthorizedEntry(ctx, entryId); !ok {
+        return
+    }
+    options, ok := getListOptions(ctx)
+    if !ok {
+        return
+    }
+    deployments, cursor, err := persistence.getEntryDeployments(entryId, options)
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    listResponse(ctx, deployments, cursor)
+}
+
+// API route used to retrieve restarts of crashed containers of a
+// particular repo entry. Results are paginated and sorted by creation date by default
+func(api GoGetGitAPI) GetContainerRestarts(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request for container restarts for entry ID %s", entryId))
+    if _, ok := getAuthorizedEntry(ctx, entryId); !ok {
+        return
+    }
+    options, ok := getListOptions(ctx)
+    if !ok {
+        return
+    }
+    restarts, cursor, err := persistence.getEntryContainerRestarts(entryId, options)
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    listResponse(ctx, restarts, cursor)
+}
+
+// API route used to manually redeploy a repo entry. an optional ref or
+// commit SHA can be given to deploy a specific revision. Note that the
+// request body can be omitted entirely to redeploy the current revision
+func(api GoGetGitAPI) DeployRegistryEntry(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    var requestBody ManualDeployRequest
+    if err := ctx.ShouldBindJSON(&requestBody); err != nil && err != io.EOF {
+        log.Error(fmt.Sprintf("received invalid request body"))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    if len(requestBody.Ref) > 0 && len(requestBody.Sha) > 0 {
+        log.Error(fmt.Sprintf("received deploy request with both ref and sha"))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to deploy entry %s from user %s with body %+v", entryId, getUser(ctx), requestBody))
+    entry, ok := getAuthorizedEntry(ctx, entryId)
+    if !ok {
+        return
+    }
+
+    ref, sha := requestBody.Ref, requestBody.Sha
+    if len(ref) == 0 && len(sha) == 0 {
+        ref, sha, err = getRedeployTarget(entry)
+        if err != nil {
+            StandardHTTP.InternalServerError(ctx)
+            return
+        }
+    }
+    deployment, err := triggerDeployment(entry, deploymentRequest{ Trigger: TriggerManual, Ref: ref, CommitSha: sha, TriggeredBy: getUser(ctx) })
+    if err != nil {
+        log.Error(fmt.Errorf("unable to trigger deployment for entry %s: %v", entryId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "message": "successfully triggered deployment", "payload": deployment})
+}
+
+// API route used to roll a repo entry back to a previous release. an
+// optional commit SHA can be given to roll back to a specific release,
+// else the release deployed before the current release is started
+func(api GoGetGitAPI) RollbackRegistryEntry(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    var requestBody RollbackRequest
+    if err := ctx.ShouldBindJSON(&requestBody); err != nil && err != io.EOF {
+        log.Error(fmt.Sprintf("received invalid request body"))
+        StandardHTTP.InvalidRequestBody(ctx)
+        return
+    }
+    log.Debug(fmt.Sprintf("received request to roll back entry %s from user %s with body %+v", entryId, getUser(ctx), requestBody))
+    entry, ok := getAuthorizedEntry(ctx, entryId)
+    if !ok {
+        return
+    }
+    deployment, err := triggerRollback(entry, requestBody.Sha, getUser(ctx))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to trigger rollback for entry %s: %v", entryId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "message": "successfully triggered rollback", "payload": deployment})
+}
+
+// API route used to retrieve a particular deployment by deployment ID
+func(api GoGetGitAPI) GetDeployment(ctx *gin.Context) {
+    deployment, ok := getAuthorizedDeployment(ctx)
+    if !ok {
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": deployment})
+}
+
+// function used to retrieve deployment from deployment ID parameter
+// and check that the requesting user owns the deployed entry. The HTTP
+// response is written and false is returned if the deployment cannot be accessed
+func getAuthorizedDeployment(ctx *gin.Context) (Deployment, bool) {
+    deploymentId, err := uuid.Parse(ctx.Param("deploymentId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("deploymentId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return Deployment{}, false
+    }
+    deployment, err := persistence.getDeployment(deploymentId)
     if err != nil {
         switch err {
         case pgx.ErrNoRows:
-            ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": []GitHookEntry{}})
-            return
+            StandardHTTP.NotFound(ctx)
         default:
             StandardHTTP.InternalServerError(ctx)
+        }
+        return deployment, false
+    }
+    if _, ok := getAuthorizedEntry(ctx, deployment.EntryId); !ok {
+        return deployment, false
+    }
+    return deployment, true
+}
+
+// internal API route used by the daemon to retrieve the credentials
+// required to clone the repo of an entry. credentials are fetched
+// before each clone or fetch and are never stored by the daemon
+func(api GoGetGitAPI) GetEntryCredentials(ctx *gin.Context) {
+    entryId, err := uuid.Parse(ctx.Param("entryId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("entryId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return
+    }
+    entry, err := persistence.getRepoEntry(entryId)
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return
+    }
+    credentials, err := getEntryCredentials(entry)
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    log.Info(fmt.Sprintf("issued %s credentials for entry %s to daemon", credentials.CredentialType, entryId))
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": credentials})
+}
+
+// API route used to retrieve build logs of a deployment. lines are
+// paginated by sequence number, where the cursor of the next page is
+// the sequence number of the last returned line. Logs are streamed as
+// Server-Sent Events until the deployment has finished if follow=true
+func(api GoGetGitAPI) GetDeploymentLogs(ctx *gin.Context) {
+    deployment, ok := getAuthorizedDeployment(ctx)
+    if !ok {
+        return
+    }
+    var after int64
+    if cursor := ctx.Query("cursor"); len(cursor) > 0 {
+        value, err := strconv.ParseInt(cursor, 10, 64)
+        if err != nil || value < 0 {
+            log.Error(fmt.Sprintf("received invalid log cursor %s", cursor))
+            StandardHTTP.InvalidRequest(ctx)
             return
         }
+        after = value
+    }
+    if ctx.Query("follow") == "true" {
+        streamDeploymentLogs(ctx, deployment.DeploymentId, after)
+        return
+    }
+
+    limit := DefaultLogPageSize
+    if value := ctx.Query("limit"); len(value) > 0 {
+        parsed, err := strconv.Atoi(value)
+        if err != nil || parsed < 1 || parsed > MaxLogPageSize {
+            log.Error(fmt.Sprintf("received invalid log limit %s", value))
+            StandardHTTP.InvalidRequest(ctx)
+            return
+        }
+        limit = parsed
+    }
+    // request one additional line to determine if there are more pages
+    lines, err := persistence.getDeploymentLogs(deployment.DeploymentId, after, limit + 1)
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    cursor := ""
+    if len(lines) > limit {
+        lines = lines[:limit]
+        cursor = strconv.FormatInt(lines[len(lines) - 1].Sequence, 10)
     }
-    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": entries})
-}
\ No newline at end of file
+    listResponse(ctx, lines, cursor)
+}
+
+// API route used to list dead-lettered events. dead letters can be
+// filtered by the queue that they were originally delivered to. Note
+// that payloads are omitted and can be retrieved per dead letter
+func(api GoGetGitAPI) GetDeadLetters(ctx *gin.Context) {
+    log.Debug(fmt.Sprintf("received request for dead letters from user %s", getUser(ctx)))
+    options, ok := getListOptions(ctx)
+    if !ok {
+        return
+    }
+    deadLetters, cursor, err := persistence.getDeadLetters(ctx.Query("queue"), options)
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    listResponse(ctx, deadLetters, cursor)
+}
+
+// API route used to retrieve dead-lettered event along with its payload
+func(api GoGetGitAPI) GetDeadLetter(ctx *gin.Context) {
+    deadLetter, ok := getDeadLetter(ctx)
+    if !ok {
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": deadLetter})
+}
+
+// API route used to requeue dead-lettered event. events are published
+// to the queue that they were originally delivered to with a fresh set
+// of delivery attempts, and the dead letter is removed once published
+func(api GoGetGitAPI) RequeueDeadLetter(ctx *gin.Context) {
+    deadLetter, ok := getDeadLetter(ctx)
+    if !ok {
+        return
+    }
+    log.Info(fmt.Sprintf("user %s requeueing dead letter %s to queue %s", getUser(ctx), deadLetter.DeadLetterId, deadLetter.Queue))
+    if err := requeueDeadLetter(deadLetter); err != nil {
+        log.Error(fmt.Errorf("unable to requeue dead letter %s: %v", deadLetter.DeadLetterId, err))
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "message": "successfully requeued dead letter", "payload": deadLetter})
+}
+
+// API route used to remove dead-lettered event without requeueing it
+func(api GoGetGitAPI) RemoveDeadLetter(ctx *gin.Context) {
+    deadLetter, ok := getDeadLetter(ctx)
+    if !ok {
+        return
+    }
+    log.Info(fmt.Sprintf("user %s removing dead letter %s", getUser(ctx), deadLetter.DeadLetterId))
+    if _, err := persistence.deleteDeadLetters(deadLetter.DeadLetterId, ""); err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "message": "successfully removed dead letter"})
+}
+
+// API route used to purge all dead-lettered events, or the dead letters
+// of a single queue if a queue is given
+func(api GoGetGitAPI) PurgeDeadLetters(ctx *gin.Context) {
+    log.Info(fmt.Sprintf("user %s purging dead letters of queue '%s'", getUser(ctx), ctx.Query("queue")))
+    count, err := persistence.deleteDeadLetters(uuid.Nil, ctx.Query("queue"))
+    if err != nil {
+        StandardHTTP.InternalServerError(ctx)
+        return
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "message": "successfully purged dead letters", "payload": gin.H{ "purged": count }})
+}
+
+// function used to retrieve dead letter from the ID in the request path.
+// The HTTP response is written and false is returned if the dead letter
+// cannot be retrieved
+func getDeadLetter(ctx *gin.Context) (DeadLetter, bool) {
+    deadLetterId, err := uuid.Parse(ctx.Param("deadLetterId"))
+    if err != nil {
+        log.Error(fmt.Sprintf("received invalid uuid %s", ctx.Param("deadLetterId")))
+        StandardHTTP.InvalidRequest(ctx)
+        return DeadLetter{}, false
+    }
+    deadLetter, err := persistence.getDeadLetter(deadLetterId)
+    if err != nil {
+        switch err {
+        case pgx.ErrNoRows:
+            StandardHTTP.NotFound(ctx)
+        default:
+            StandardHTTP.InternalServerError(ctx)
+        }
+        return deadLetter, false
+    }
+    return deadLetter, true
+}
@@ -4,6 +4,23 @@ import (
     "github.com/gin-gonic/gin"
 )
 
+const (
+    DefaultTrackedBranch = "master"
+    DefaultTagPattern = "*"
+    // define modes that deployments can be triggered by
+    DeployOnBranch = "branch"
+    DeployOnTag = "tag"
+    DeployOnRelease = "release"
+    // define credentials that the daemon can use to clone repos
+    CredentialNone = "none"
+    CredentialToken = "token"
+    CredentialDeployKey = "deploy_key"
+    // builder used to auto-detect build strategy of repos
+    BuilderAuto = "auto"
+    // header used by the daemon to authenticate against internal routes
+    DaemonSecretHeader = "X-Go-Get-Git-Daemon-Secret"
+)
+
 // define interface used to store a collection of standard HTTP responses
 type StandardHTTPResponse interface{
     Success(ctx *gin.Context)
@@ -55,6 +72,10 @@ func(response StandardJSONResponse) InternalServerError(ctx *gin.Context) {
     ctx.AbortWithStatusJSON(500, gin.H{ "http_code": 500, "success": false, "message": "internal server error" })
 }
 
+func(response StandardJSONResponse) BadGateway(ctx *gin.Context) {
+    ctx.AbortWithStatusJSON(502, gin.H{ "http_code": 502, "success": false, "message": "bad gateway" })
+}
+
 func(response StandardJSONResponse) FeatureNotSupported(ctx *gin.Context) {
     ctx.AbortWithStatusJSON(503, gin.H{ "http_code": 503, "success": false, "message": "feature not yet supported" })
 }
\ No newline at end of file
@@ -0,0 +1,141 @@
+package api
+
+import (
+    "fmt"
+    "bytes"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/pem"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+// function used to generate new deploy key. keys are generated as
+// ECDSA P-256 keys, since both the PEM encoded private key and the
+// authorized key format of the public key are supported by OpenSSH
+func generateDeployKey() (DeployKey, error) {
+    private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return DeployKey{}, err
+    }
+    der, err := x509.MarshalECPrivateKey(private)
+    if err != nil {
+        return DeployKey{}, err
+    }
+
+    // encode public key in SSH wire format (RFC 5656)
+    var blob bytes.Buffer
+    for _, field := range([][]byte{
+        []byte("ecdsa-sha2-nistp256"),
+        []byte("nistp256"),
+        elliptic.Marshal(elliptic.P256(), private.PublicKey.X, private.PublicKey.Y),
+    }) {
+        binary.Write(&blob, binary.BigEndian, uint32(len(field)))
+        blob.Write(field)
+    }
+    digest := sha256.Sum256(blob.Bytes())
+    return DeployKey{
+        PublicKey: "ecdsa-sha2-nistp256 " + base64.StdEncoding.EncodeToString(blob.Bytes()),
+        PrivateKey: string(pem.EncodeToMemory(&pem.Block{ Type: "EC PRIVATE KEY", Bytes: der })),
+        Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(digest[:]),
+    }, nil
+}
+
+// function used to generate new deploy key and register the key
+// as a read-only deploy key on the repo of an entry
+func createEntryDeployKey(owner, repo, token string) (DeployKey, error) {
+    key, err := generateDeployKey()
+    if err != nil {
+        log.Error(fmt.Errorf("unable to generate deploy key: %v", err))
+        return key, err
+    }
+    key.GitKeyId, err = createGitDeployKey(owner, repo, token, fmt.Sprintf("%s (%s)", ApplicationId, key.Fingerprint), key.PublicKey)
+    return key, err
+}
+
+// function used to remove the deploy key of an entry from the git
+// server. entries without deploy keys are ignored
+func deleteEntryDeployKey(entry GitRepoEntry) error {
+    key, err := persistence.getDeployKey(entry.EntryId)
+    switch err {
+    case nil:
+        return deleteGitDeployKey(entry.RepoOwner, entry.RepoName, entry.AccessToken, key.GitKeyId)
+    case pgx.ErrNoRows:
+        return nil
+    default:
+        return err
+    }
+}
+
+// function used to re-create the deploy key of an entry after the
+// credential type, owner or name of the repo have been changed. The
+// new key is registered as a saga step that is compensated by removing
+// the key, and the stored key is replaced in the given transaction. the
+// previous key is only removed by the returned cleanup function once the
+// transaction has been committed, and failures to remove previous keys
+// are logged but do not fail the update
+func resyncEntryDeployKey(tx Persistence, s *saga, previous, updated GitRepoEntry) (func(), error) {
+    var previousKey *DeployKey
+    if previous.CredentialType == CredentialDeployKey {
+        key, err := tx.getDeployKey(previous.EntryId)
+        switch err {
+        case nil:
+            previousKey = &key
+        case pgx.ErrNoRows:
+        default:
+            return nil, err
+        }
+    }
+
+    if updated.CredentialType == CredentialDeployKey {
+        var key DeployKey
+        err := s.run("create_deploy_key", func() error {
+            created, err := createEntryDeployKey(updated.RepoOwner, updated.RepoName, updated.AccessToken)
+            key = created
+            return err
+        }, func() error {
+            return deleteGitDeployKey(updated.RepoOwner, updated.RepoName, updated.AccessToken, key.GitKeyId)
+        })
+        if err != nil {
+            return nil, err
+        }
+        if err := tx.createDeployKey(updated.EntryId, key); err != nil {
+            return nil, err
+        }
+    } else if err := tx.deleteDeployKey(updated.EntryId); err != nil {
+        return nil, err
+    }
+
+    return func() {
+        if previousKey == nil {
+            return
+        }
+        if err := deleteGitDeployKey(previous.RepoOwner, previous.RepoName, previous.AccessToken, previousKey.GitKeyId); err != nil {
+            log.Warn(fmt.Sprintf("unable to remove previous deploy key for entry %s: %v", updated.EntryId, err))
+        }
+    }, nil
+}
+
+// function used to retrieve the credentials that the daemon should
+// use to clone the repo of an entry
+func getEntryCredentials(entry GitRepoEntry) (GitCredentials, error) {
+    credentials := GitCredentials{ CredentialType: entry.CredentialType }
+    switch entry.CredentialType {
+    case CredentialToken:
+        credentials.Username = entry.RepoOwner
+        credentials.Token = entry.AccessToken
+    case CredentialDeployKey:
+        key, err := persistence.getDeployKey(entry.EntryId)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to retrieve deploy key for entry %s: %v", entry.EntryId, err))
+            return credentials, err
+        }
+        credentials.PrivateKey = key.PrivateKey
+    }
+    return credentials, nil
+}
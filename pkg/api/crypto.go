@@ -0,0 +1,211 @@
+package api
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "io"
+    "io/ioutil"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    log "github.com/sirupsen/logrus"
+)
+
+var (
+    keyring *Keyring
+    InvalidKeyringError = errors.New("invalid token encryption keyring")
+    UnknownKeyError = errors.New("token encrypted with unknown key")
+    InvalidCiphertextError = errors.New("invalid encrypted token")
+)
+
+// prefix used to identify tokens that have been encrypted. values
+// without the prefix are treated as legacy plaintext tokens
+const envelopePrefix = "enc:v1:"
+
+// struct used to store the key encryption keys used to encrypt
+// tokens. tokens are always encrypted with the primary key, while
+// the remaining keys are retained to decrypt tokens after rotation
+type Keyring struct {
+    Primary string
+    keys    map[string][]byte
+}
+
+// function used to load keyring from key file if configured, else
+// from the environment. Keys are defined as a comma or newline
+// separated list of <key-id>:<base64 key> pairs, where the first key
+// is used as the primary key and all keys must be 32 bytes long
+func LoadKeyring() {
+    definition := TokenKeys
+    if len(TokenKeyFile) > 0 {
+        content, err := ioutil.ReadFile(TokenKeyFile)
+        if err != nil {
+            log.Fatal(fmt.Errorf("unable to read token key file %s: %v", TokenKeyFile, err))
+        }
+        definition = string(content)
+    }
+    parsed, err := ParseKeyring(definition)
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to load token encryption keys: %v", err))
+    }
+    log.Info(fmt.Sprintf("loaded %d token encryption key(s) with primary key %s", len(parsed.keys), parsed.Primary))
+    keyring = parsed
+}
+
+// function used to parse keyring from key definition
+func ParseKeyring(definition string) (*Keyring, error) {
+    ring := &Keyring{ keys: map[string][]byte{} }
+    entries := strings.FieldsFunc(definition, func(c rune) bool {
+        return c == ',' || c == '\n'
+    })
+    for _, entry := range(entries) {
+        entry = strings.TrimSpace(entry)
+        if len(entry) == 0 || strings.HasPrefix(entry, "#") {
+            continue
+        }
+        parts := strings.SplitN(entry, ":", 2)
+        if len(parts) != 2 || len(parts[0]) == 0 {
+            return nil, InvalidKeyringError
+        }
+        key, err := base64.StdEncoding.DecodeString(parts[1])
+        if err != nil || len(key) != 32 {
+            return nil, InvalidKeyringError
+        }
+        if _, exists := ring.keys[parts[0]]; exists {
+            return nil, InvalidKeyringError
+        }
+        if len(ring.Primary) == 0 {
+            ring.Primary = parts[0]
+        }
+        ring.keys[parts[0]] = key
+    }
+    if len(ring.Primary) == 0 {
+        return nil, InvalidKeyringError
+    }
+    return ring, nil
+}
+
+// function used to encrypt token with envelope encryption. A random
+// data key is generated for each token and used to encrypt the token,
+// and the data key is then encrypted with the primary key of the keyring
+func (ring *Keyring) Encrypt(token string) (string, error) {
+    dataKey := make([]byte, 32)
+    if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+        return "", err
+    }
+    wrappedKey, err := seal(ring.keys[ring.Primary], dataKey)
+    if err != nil {
+        return "", err
+    }
+    ciphertext, err := seal(dataKey, []byte(token))
+    if err != nil {
+        return "", err
+    }
+    return envelopePrefix + strings.Join([]string{
+        ring.Primary,
+        base64.RawStdEncoding.EncodeToString(wrappedKey),
+        base64.RawStdEncoding.EncodeToString(ciphertext),
+    }, ":"), nil
+}
+
+// function used to decrypt token encrypted with Encrypt(). Note that
+// legacy plaintext tokens are returned as is
+func (ring *Keyring) Decrypt(value string) (string, error) {
+    if !IsEncrypted(value) {
+        return value, nil
+    }
+    keyId, wrappedKey, ciphertext, err := parseEnvelope(value)
+    if err != nil {
+        return "", err
+    }
+    key, ok := ring.keys[keyId]
+    if !ok {
+        return "", UnknownKeyError
+    }
+    dataKey, err := unseal(key, wrappedKey)
+    if err != nil {
+        return "", err
+    }
+    token, err := unseal(dataKey, ciphertext)
+    if err != nil {
+        return "", err
+    }
+    return string(token), nil
+}
+
+// function used to determine if a stored token needs to be
+// re-encrypted with the primary key of the keyring
+func (ring *Keyring) NeedsRotation(value string) bool {
+    if !IsEncrypted(value) {
+        return true
+    }
+    keyId, _, _, err := parseEnvelope(value)
+    return err != nil || keyId != ring.Primary
+}
+
+// function used to determine if stored value is an encrypted token
+func IsEncrypted(value string) bool {
+    return strings.HasPrefix(value, envelopePrefix)
+}
+
+// function used to generate a redacted fingerprint of a token that
+// can be returned to users to identify which token is in use
+func TokenFingerprint(token string) string {
+    digest := sha256.Sum256([]byte(token))
+    return "sha256:" + hex.EncodeToString(digest[:])[:12]
+}
+
+// helper function used to split encrypted token into components
+func parseEnvelope(value string) (string, []byte, []byte, error) {
+    parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+    if len(parts) != 3 {
+        return "", nil, nil, InvalidCiphertextError
+    }
+    wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+    if err != nil {
+        return "", nil, nil, InvalidCiphertextError
+    }
+    ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+    if err != nil {
+        return "", nil, nil, InvalidCiphertextError
+    }
+    return parts[0], wrappedKey, ciphertext, nil
+}
+
+// helper function used to encrypt plaintext with AES-GCM. the random
+// nonce is prepended to the returned ciphertext
+func seal(key, plaintext []byte) ([]byte, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+    return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// helper function used to decrypt ciphertext generated by seal()
+func unseal(key, ciphertext []byte) ([]byte, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    if len(ciphertext) < gcm.NonceSize() {
+        return nil, InvalidCiphertextError
+    }
+    nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+    return gcm.Open(nil, nonce, sealed, nil)
+}
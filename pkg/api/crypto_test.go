@@ -0,0 +1,84 @@
+package api
+
+import (
+    "strings"
+    "testing"
+    "encoding/base64"
+)
+
+// helper function used to generate base64 encoded key filled with byte
+func testKey(b byte) string {
+    return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string([]byte{ b }), 32)))
+}
+
+func TestParseKeyring(t *testing.T) {
+    tests := []struct {
+        name       string
+        definition string
+        primary    string
+        keys       int
+        err        error
+    }{
+        { "newline separated keys with comments", "# rotated keys\nb:" + testKey(2) + "\n\na:" + testKey(1) + "\n", "b", 2, nil },
+        { "empty definition", "", "", 0, InvalidKeyringError },
+        { "short key", "a:" + base64.StdEncoding.EncodeToString([]byte("short")), "", 0, InvalidKeyringError },
+        { "duplicate key ID", "a:" + testKey(1) + ",a:" + testKey(2), "", 0, InvalidKeyringError },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            ring, err := ParseKeyring(test.definition)
+            if err != test.err {
+                t.Fatalf("expected error %v, got %v", test.err, err)
+            }
+            if err != nil {
+                return
+            }
+            if ring.Primary != test.primary || len(ring.keys) != test.keys {
+                t.Errorf("expected primary %s with %d key(s), got primary %s with %d key(s)", test.primary, test.keys, ring.Primary, len(ring.keys))
+            }
+        })
+    }
+}
+
+func TestKeyringEncryptDecrypt(t *testing.T) {
+    previous, _ := ParseKeyring("a:" + testKey(1))
+    rotated, _ := ParseKeyring("b:" + testKey(2) + ",a:" + testKey(1))
+    other, _ := ParseKeyring("c:" + testKey(3))
+
+    encrypted, err := previous.Encrypt("secret-token")
+    if err != nil {
+        t.Fatalf("unable to encrypt token: %v", err)
+    }
+    if !IsEncrypted(encrypted) || strings.Contains(encrypted, "secret-token") {
+        t.Fatalf("expected encrypted token, got %s", encrypted)
+    }
+    tampered := encrypted[:len(encrypted) - 4] + "AAAA"
+
+    tests := []struct {
+        name     string
+        ring     *Keyring
+        value    string
+        token    string
+        rotation bool
+        err      bool
+    }{
+        { "rotated keyring with previous key", rotated, encrypted, "secret-token", true, false },
+        { "legacy plaintext token", rotated, "plaintext-token", "plaintext-token", true, false },
+        { "unknown key", other, encrypted, "", true, true },
+        { "tampered ciphertext", previous, tampered, "", false, true },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            token, err := test.ring.Decrypt(test.value)
+            if (err != nil) != test.err {
+                t.Fatalf("expected error %t, got %v", test.err, err)
+            }
+            if token != test.token {
+                t.Errorf("expected token %s, got %s", test.token, token)
+            }
+            if rotation := test.ring.NeedsRotation(test.value); rotation != test.rotation {
+                t.Errorf("expected rotation %t, got %t", test.rotation, rotation)
+            }
+        })
+    }
+}
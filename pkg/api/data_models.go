@@ -10,6 +10,51 @@ type NewRegistryEntry struct {
     RepoUrl         string `json:"repo_url" binding:"required"`
     RepoOwner       string `json:"repo_owner" binding:"required"`
     RepoAccessToken string `json:"repo_access_token" binding:"required"`
+    TrackedBranch   string `json:"tracked_branch"`
+    DeployTrigger   string `json:"deploy_trigger" binding:"omitempty,oneof=branch tag release"`
+    TagPattern      string `json:"tag_pattern"`
+    CredentialType  string `json:"credential_type" binding:"omitempty,oneof=none token deploy_key"`
+    Builder         string `json:"builder" binding:"omitempty,oneof=auto compose dockerfile makefile script"`
+    HealthCheckUrl     string `json:"health_check_url" binding:"omitempty,url"`
+    HealthCheckTimeout int    `json:"health_check_timeout" binding:"omitempty,min=1,max=3600"`
+    AutoRollback       bool   `json:"auto_rollback"`
+    TargetNode         string `json:"target_node" binding:"omitempty,max=255"`
+}
+
+// function used to return a copy of a registry entry request that can be
+// logged. the access token is replaced with a fingerprint of the token
+func (body NewRegistryEntry) redacted() NewRegistryEntry {
+    body.RepoAccessToken = TokenFingerprint(body.RepoAccessToken)
+    return body
+}
+
+// struct used to parse partial updates to registry entries. fields
+// that are not included in the request body are left unchanged
+type UpdateRegistryEntry struct {
+    RepoName		*string `json:"repo_name" binding:"omitempty,min=1"`
+    RepoUrl         *string `json:"repo_url" binding:"omitempty,min=1"`
+    RepoOwner       *string `json:"repo_owner" binding:"omitempty,min=1"`
+    RepoAccessToken *string `json:"repo_access_token" binding:"omitempty,min=1"`
+    TrackedBranch   *string `json:"tracked_branch" binding:"omitempty,min=1"`
+    DeployTrigger   *string `json:"deploy_trigger" binding:"omitempty,oneof=branch tag release"`
+    TagPattern      *string `json:"tag_pattern" binding:"omitempty,min=1"`
+    CredentialType  *string `json:"credential_type" binding:"omitempty,oneof=none token deploy_key"`
+    Builder         *string `json:"builder" binding:"omitempty,oneof=auto compose dockerfile makefile script"`
+    HealthCheckUrl     *string `json:"health_check_url" binding:"omitempty,url|len=0"`
+    HealthCheckTimeout *int    `json:"health_check_timeout" binding:"omitempty,min=0,max=3600"`
+    AutoRollback       *bool   `json:"auto_rollback"`
+    TargetNode         *string `json:"target_node" binding:"omitempty,max=255"`
+}
+
+// struct used to parse manual deployment requests. the ref or commit
+// SHA to deploy are optional, and at most one of them can be set
+type ManualDeployRequest struct {
+    Ref string `json:"ref"`
+    Sha string `json:"sha" binding:"omitempty,hexadecimal,min=7,max=40"`
+}
+
+type RollbackRequest struct {
+    Sha string `json:"sha" binding:"omitempty,hexadecimal,min=7,max=40"`
 }
 
 type GitHookConfig struct {
@@ -26,6 +71,50 @@ type NewGitHookRequest struct {
     Config GitHookConfig `json:"config"`
 }
 
+// struct used to store git hook configuration alongside the ID
+// assigned to the hook by the Github API. note that the request
+// is embedded to remain compatible with previously stored hooks
+type GitHookMeta struct {
+    NewGitHookRequest
+    GitHookId int64  `json:"git_hook_id"`
+    RepoOwner string `json:"repo_owner"`
+    RepoName  string `json:"repo_name"`
+}
+
+// struct used to parse hooks returned by the Github API. note
+// that only the fields required to identify hooks are parsed
+type GitHookResponse struct {
+    Id     int64 `json:"id"`
+    Config struct {
+        Url string `json:"url"`
+    } `json:"config"`
+}
+
+// struct used to parse commits returned by the Github API
+type GitCommitResponse struct {
+    Sha string `json:"sha"`
+}
+
+// struct used to register deploy keys with the Github API
+type NewGitDeployKeyRequest struct {
+    Title    string `json:"title"`
+    Key      string `json:"key"`
+    ReadOnly bool   `json:"read_only"`
+}
+
+// struct used to parse deploy keys returned by the Github API
+type GitDeployKeyResponse struct {
+    Id int64 `json:"id"`
+}
+
+// struct used to send clone credentials of repo entries to the daemon
+type GitCredentials struct {
+    CredentialType string `json:"credentialType"`
+    Username       string `json:"username,omitempty"`
+    Token          string `json:"token,omitempty"`
+    PrivateKey     string `json:"privateKey,omitempty"`
+}
+
 type GitEventHookResponse struct {
     Ref string `json:"ref" binding:"required"`
 }
@@ -38,8 +127,21 @@ type GitRepoEntry struct {
     EntryId     uuid.UUID `json:"entryId"`
     Uid 	    string    `json:"uid"`
     RepoUrl     string    `json:"repoUrl"`
-    AccessToken string    `json:"accessToken"`
-    CreatedAt   time.Time `json:"createdAt"`
+    RepoName    string    `json:"repoName"`
+    RepoOwner   string    `json:"repoOwner"`
+    TrackedBranch string  `json:"trackedBranch"`
+    DeployTrigger string  `json:"deployTrigger"`
+    TagPattern    string  `json:"tagPattern"`
+    CredentialType string `json:"credentialType"`
+    Builder       string  `json:"builder"`
+    HealthCheckUrl     string `json:"healthCheckUrl"`
+    HealthCheckTimeout int    `json:"healthCheckTimeout"`
+    AutoRollback       bool   `json:"autoRollback"`
+    TargetNode         string `json:"targetNode"`
+    AccessToken      string    `json:"-"`
+    TokenFingerprint string    `json:"tokenFingerprint"`
+    TokenUpdatedAt   time.Time `json:"tokenUpdatedAt"`
+    CreatedAt        time.Time `json:"createdAt"`
 }
 
 type GitHookEntry struct {
@@ -48,3 +150,86 @@ type GitHookEntry struct {
     CreatedAt time.Time   `json:"createdAt"`
     Meta      interface{} `json:"meta"`
 }
+
+// struct used to store state of repo registrations. Note that the
+// entry ID is only set once the repo entry has been created
+type Registration struct {
+    RegistrationId uuid.UUID  `json:"registrationId"`
+    EntryId        *uuid.UUID `json:"entryId"`
+    Uid            string     `json:"uid"`
+    RepoUrl        string     `json:"repoUrl"`
+    State          string     `json:"state"`
+    Step           string     `json:"step"`
+    Error          string     `json:"error"`
+    CreatedAt      time.Time  `json:"createdAt"`
+    UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// struct used to store deployments of repo entries. Note that start
+// and finish timestamps are only set once the daemon reports them
+type Deployment struct {
+    DeploymentId  uuid.UUID  `json:"deploymentId"`
+    EntryId       uuid.UUID  `json:"entryId"`
+    CommitSha     string     `json:"commitSha"`
+    CommitMessage string     `json:"commitMessage"`
+    Ref           string     `json:"ref"`
+    Trigger       string     `json:"trigger"`
+    State         string     `json:"state"`
+    TriggeredBy   string     `json:"triggeredBy"`
+    Error         string     `json:"error"`
+    FailedStep    string     `json:"failedStep"`
+    DurationMs    int64      `json:"durationMs"`
+    ContainerIds  []string   `json:"containerIds"`
+    CreatedAt     time.Time  `json:"createdAt"`
+    StartedAt     *time.Time `json:"startedAt"`
+    FinishedAt    *time.Time `json:"finishedAt"`
+}
+
+// struct used to store deploy keys generated for repo entries
+type DeployKey struct {
+    EntryId     uuid.UUID `json:"entryId"`
+    GitKeyId    int64     `json:"gitKeyId"`
+    PublicKey   string    `json:"publicKey"`
+    PrivateKey  string    `json:"-"`
+    Fingerprint string    `json:"fingerprint"`
+    CreatedAt   time.Time `json:"createdAt"`
+}
+
+// struct used to store lines of deployment build logs
+type DeploymentLogLine struct {
+    Sequence  int64     `json:"sequence"`
+    Stream    string    `json:"stream"`
+    Line      string    `json:"line"`
+    Timestamp time.Time `json:"timestamp"`
+}
+
+// struct used to store restarts of crashed containers of repo entries
+type ContainerRestart struct {
+    RestartId     uuid.UUID `json:"restartId"`
+    EntryId       uuid.UUID `json:"entryId"`
+    ContainerId   string    `json:"containerId"`
+    ContainerName string    `json:"containerName"`
+    ExitCode      int       `json:"exitCode"`
+    Attempt       int       `json:"attempt"`
+    Initiator     string    `json:"initiator"`
+    Error         string    `json:"error"`
+    CreatedAt     time.Time `json:"createdAt"`
+}
+
+// struct used to store event that could not be processed after all
+// delivery attempts. the queue is the queue that the event was originally
+// delivered to, and is used to requeue the event. Note that the payload
+// is only returned when a single dead letter is retrieved
+type DeadLetter struct {
+    DeadLetterId uuid.UUID  `json:"deadLetterId"`
+    EventId      *uuid.UUID `json:"eventId"`
+    EventType    string     `json:"eventType"`
+    EntryId      *uuid.UUID `json:"entryId"`
+    Queue        string     `json:"queue"`
+    Exchange     string     `json:"exchange"`
+    RoutingKey   string     `json:"routingKey"`
+    Attempts     int        `json:"attempts"`
+    Error        string     `json:"error"`
+    Payload      string     `json:"payload,omitempty"`
+    CreatedAt    time.Time  `json:"createdAt"`
+}
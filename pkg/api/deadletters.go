@@ -0,0 +1,95 @@
+package api
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "encoding/json"
+    "github.com/PSauerborn/go-get-git/pkg/broker"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// define interval that dead letters are requeued at if they cannot be stored
+var DeadLetterRetryInterval = 5 * time.Second
+
+// function used to listen for events moved to the dead-letter queue by
+// the API and daemons. dead letters are stored so that they can be
+// inspected and requeued. Note that the function blocks while listening
+// and should be run in a goroutine
+func ListenForDeadLetters() {
+    consumer := broker.ConsumerConfig{ Queue: DeadLetterQueueName, Prefetch: 1 }
+    err := broker.Listen(context.Background(), exchangeConfig(), consumer, processDeadLetter)
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to create dead-letter listener: %v", err))
+    }
+}
+
+// function used to store dead-lettered event. dead letters that cannot be
+// stored are requeued after a delay rather than retried, since retries
+// would replace the headers that describe the original failure
+func processDeadLetter(delivery *broker.Delivery) {
+    deadLetter := newDeadLetter(delivery)
+    log.Warn(fmt.Sprintf("received dead letter %s from queue %s after %d attempt(s): %s", deadLetter.DeadLetterId, deadLetter.Queue, deadLetter.Attempts, deadLetter.Error))
+    if err := persistence.createDeadLetter(deadLetter); err != nil {
+        time.Sleep(DeadLetterRetryInterval)
+        delivery.Requeue()
+        return
+    }
+    delivery.Ack()
+}
+
+// helper function used to create dead letter from delivery. the event ID,
+// type and entry ID are extracted from the payload if it is a valid event.
+// dead letters are identified by the message ID and the queue that the
+// message was dead-lettered from, so that events broadcast to several
+// queues are stored for each queue, while redelivered dead letters are only
+// stored once. Note that the attempts of the original queue are stored,
+// since the dead-letter queue is consumed without retries
+func newDeadLetter(delivery *broker.Delivery) DeadLetter {
+    deadLetterId := uuid.New()
+    if messageId, err := uuid.Parse(delivery.MessageId); err == nil {
+        deadLetterId = uuid.NewSHA1(messageId, []byte(delivery.Queue))
+    }
+    deadLetter := DeadLetter{
+        DeadLetterId: deadLetterId,
+        Queue: delivery.Queue,
+        Exchange: delivery.Exchange,
+        RoutingKey: delivery.RoutingKey,
+        Attempts: delivery.Attempt - 1,
+        Error: delivery.Error,
+        Payload: string(delivery.Body),
+    }
+    var event struct {
+        EventId      uuid.UUID `json:"event_id"`
+        EventType    string    `json:"event_type"`
+        EventPayload struct {
+            EntryId uuid.UUID `json:"entry_id"`
+        } `json:"event_payload"`
+    }
+    if err := json.Unmarshal(delivery.Body, &event); err == nil {
+        deadLetter.EventType = event.EventType
+        if event.EventId != uuid.Nil {
+            deadLetter.EventId = &event.EventId
+        }
+        if event.EventPayload.EntryId != uuid.Nil {
+            deadLetter.EntryId = &event.EventPayload.EntryId
+        }
+    }
+    return deadLetter
+}
+
+// function used to requeue dead-lettered event to the queue that it was
+// originally delivered to. the dead letter is removed once published.
+// Note that the event ID is kept as message ID of the requeued event
+func requeueDeadLetter(deadLetter DeadLetter) error {
+    messageId := deadLetter.DeadLetterId.String()
+    if deadLetter.EventId != nil {
+        messageId = deadLetter.EventId.String()
+    }
+    if err := broker.PublishToQueue(exchangeConfig(), deadLetter.Queue, messageId, []byte(deadLetter.Payload)); err != nil {
+        return err
+    }
+    _, err := persistence.deleteDeadLetters(deadLetter.DeadLetterId, "")
+    return err
+}
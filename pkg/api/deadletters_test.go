@@ -0,0 +1,34 @@
+package api
+
+import (
+    "testing"
+    "github.com/PSauerborn/go-get-git/pkg/broker"
+    "github.com/google/uuid"
+)
+
+func TestNewDeadLetter(t *testing.T) {
+    eventId, entryId := uuid.New(), uuid.New()
+    body := []byte(`{"event_id":"` + eventId.String() + `","event_type":"GitPushEvent","event_payload":{"entry_id":"` + entryId.String() + `"}}`)
+    first := newDeadLetter(&broker.Delivery{ MessageId: eventId.String(), Queue: "go-get-git-daemon-node-1", Attempt: 6, Body: body })
+
+    tests := []struct {
+        name     string
+        delivery *broker.Delivery
+        same     bool
+    }{
+        { "redelivered dead letter", &broker.Delivery{ MessageId: eventId.String(), Queue: "go-get-git-daemon-node-1", Attempt: 6, Body: body }, true },
+        { "dead letter of other queue", &broker.Delivery{ MessageId: eventId.String(), Queue: "go-get-git-daemon-node-2", Attempt: 6, Body: body }, false },
+        { "dead letter without message ID", &broker.Delivery{ Queue: "go-get-git-daemon-node-1", Attempt: 6, Body: body }, false },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            deadLetter := newDeadLetter(test.delivery)
+            if (deadLetter.DeadLetterId == first.DeadLetterId) != test.same {
+                t.Errorf("expected same dead letter ID %t, got %s and %s", test.same, first.DeadLetterId, deadLetter.DeadLetterId)
+            }
+        })
+    }
+    if first.EventId == nil || *first.EventId != eventId || first.EntryId == nil || *first.EntryId != entryId || first.EventType != "GitPushEvent" || first.Attempts != 5 {
+        t.Errorf("received unexpected dead letter %+v", first)
+    }
+}
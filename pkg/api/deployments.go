@@ -0,0 +1,250 @@
+package api
+
+import (
+    "fmt"
+    "time"
+    "strconv"
+    "strings"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v4"
+    log "github.com/sirupsen/logrus"
+)
+
+// define states that deployments can be in
+const (
+    DeploymentPending = "pending"
+    DeploymentBuilding = "building"
+    DeploymentSucceeded = "succeeded"
+    DeploymentFailed = "failed"
+    DeploymentSkipped = "skipped"
+)
+
+// define events that deployments can be triggered by
+const (
+    TriggerPush = "push"
+    TriggerTag = "tag"
+    TriggerRelease = "release"
+    TriggerManual = "manual"
+    TriggerRollback = "rollback"
+)
+
+// struct used to store the revision that a deployment should deploy
+// along with the event and user that triggered the deployment
+type deploymentRequest struct {
+    Trigger       string
+    Ref           string
+    CommitSha     string
+    Tag           string
+    CommitMessage string
+    TriggeredBy   string
+}
+
+// function used to record new deployment and send git push event to
+// daemon. the daemon checks out the given commit SHA if set, else the
+// given tag or ref. the latest commit is deployed if none are set. events
+// are stamped with the time they were triggered at, so that the daemon
+// never deploys a push over a push that was triggered after it
+func triggerDeployment(entry GitRepoEntry, request deploymentRequest) (Deployment, error) {
+    log.Info(fmt.Sprintf("triggering %s deployment of ref %s at commit '%s' for entry %s", request.Trigger, request.Ref, request.CommitSha, entry.EntryId))
+    deployment := Deployment{
+        DeploymentId: uuid.New(),
+        EntryId: entry.EntryId,
+        CommitSha: request.CommitSha,
+        CommitMessage: request.CommitMessage,
+        Ref: request.Ref,
+        Trigger: request.Trigger,
+        State: DeploymentPending,
+        TriggeredBy: request.TriggeredBy,
+    }
+    // get file directory of application from database
+    dir, err := persistence.getEntryDirectory(entry.EntryId)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to fetch application directory: %s", err))
+        return deployment, err
+    }
+    if err := persistence.createDeployment(deployment); err != nil {
+        return deployment, err
+    }
+
+    // generate rabbitMQ event and send over rabbit server to daemon
+    payload := events.GitPushEvent{
+        EntryId: entry.EntryId,
+        DeploymentId: deployment.DeploymentId,
+        RepoUrl: entry.RepoUrl,
+        ApplicationDirectory: dir,
+        Tag: request.Tag,
+        Ref: request.Ref,
+        CommitSha: request.CommitSha,
+        Builder: entry.Builder,
+        HealthCheckUrl: entry.HealthCheckUrl,
+        HealthCheckTimeout: entry.HealthCheckTimeout,
+        AutoRollback: entry.AutoRollback,
+        Pusher: request.TriggeredBy,
+        HeadCommitMessage: request.CommitMessage,
+        TriggeredAt: time.Now(),
+    }
+    event := events.New("GitPushEvent", ApplicationId, deployment.DeploymentId, payload)
+    if err := sendDaemonEvent(entry.TargetNode, event); err != nil {
+        log.Error(fmt.Errorf("unable to send git push event: %v", err))
+        persistence.updateDeploymentState(deployment.DeploymentId, deploymentUpdate{ State: DeploymentFailed, Error: "unable to send deployment to daemon" })
+        return deployment, err
+    }
+    return deployment, nil
+}
+
+// function used to record new deployment and send rollback event to
+// daemon. the daemon switches to the release of the given commit SHA if
+// set, else to the release that was deployed before the current release.
+// Note that releases are started without building images
+func triggerRollback(entry GitRepoEntry, sha, triggeredBy string) (Deployment, error) {
+    log.Info(fmt.Sprintf("triggering rollback to commit '%s' for entry %s", sha, entry.EntryId))
+    deployment := Deployment{
+        DeploymentId: uuid.New(),
+        EntryId: entry.EntryId,
+        CommitSha: sha,
+        Trigger: TriggerRollback,
+        State: DeploymentPending,
+        TriggeredBy: triggeredBy,
+    }
+    dir, err := persistence.getEntryDirectory(entry.EntryId)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to fetch application directory: %s", err))
+        return deployment, err
+    }
+    if err := persistence.createDeployment(deployment); err != nil {
+        return deployment, err
+    }
+
+    payload := events.RollbackEvent{
+        EntryId: entry.EntryId,
+        DeploymentId: deployment.DeploymentId,
+        RepoUrl: entry.RepoUrl,
+        ApplicationDirectory: dir,
+        CommitSha: sha,
+        Builder: entry.Builder,
+        HealthCheckUrl: entry.HealthCheckUrl,
+        HealthCheckTimeout: entry.HealthCheckTimeout,
+    }
+    event := events.New("RollbackEvent", ApplicationId, deployment.DeploymentId, payload)
+    if err := sendDaemonEvent(entry.TargetNode, event); err != nil {
+        log.Error(fmt.Errorf("unable to send rollback event: %v", err))
+        persistence.updateDeploymentState(deployment.DeploymentId, deploymentUpdate{ State: DeploymentFailed, Error: "unable to send rollback to daemon" })
+        return deployment, err
+    }
+    return deployment, nil
+}
+
+// struct used to store results of deployments reported by the daemon.
+// Note that empty values do not overwrite previously reported values
+type deploymentUpdate struct {
+    State        string
+    Error        string
+    CommitSha    string
+    FailedStep   string
+    DurationMs   int64
+    ContainerIds []string
+}
+
+// function used to update state of deployment from build events. the
+// commit SHA reported by the daemon is stored with the deployment, since
+// deployments of tags and refs are only resolved to a commit by the daemon
+func processDeploymentState(deploymentId uuid.UUID, update deploymentUpdate) error {
+    if deploymentId == uuid.Nil {
+        log.Warn(fmt.Sprintf("received %s build event without deployment ID", update.State))
+        return nil
+    }
+    return persistence.updateDeploymentState(deploymentId, update)
+}
+
+// function used to process build completed events. deployments that
+// were skipped by the daemon are marked as skipped rather than succeeded
+func processBuildCompletedEvent(e events.BuildCompletedEvent) error {
+    state := DeploymentSucceeded
+    if e.Skipped {
+        state = DeploymentSkipped
+    }
+    return processDeploymentState(e.DeploymentId, deploymentUpdate{
+        State: state,
+        CommitSha: e.CommitSha,
+        DurationMs: e.DurationMs,
+        ContainerIds: e.ContainerIds,
+    })
+}
+
+// function used to determine which ref or commit a manual deployment
+// should deploy if none are requested. the revision of the latest
+// successful deployment is redeployed if one exists, else the tracked
+// branch is deployed if it is not a glob pattern
+func getRedeployTarget(entry GitRepoEntry) (string, string, error) {
+    previous, err := persistence.getLatestDeployment(entry.EntryId, DeploymentSucceeded)
+    switch err {
+    case nil:
+        return previous.Ref, previous.CommitSha, nil
+    case pgx.ErrNoRows:
+        if entry.DeployTrigger == DeployOnBranch && !strings.ContainsAny(entry.TrackedBranch, "*?[\\") {
+            return "refs/heads/" + entry.TrackedBranch, "", nil
+        }
+        return "", "", nil
+    default:
+        return "", "", err
+    }
+}
+
+// function used to stream build logs of deployment as Server-Sent
+// Events. new lines are polled until the deployment has finished, and
+// clients can resume streams with the Last-Event-ID header. Note that
+// the state of the deployment is read before the logs, since the daemon
+// ships all lines before reporting that the deployment has finished
+func streamDeploymentLogs(ctx *gin.Context, deploymentId uuid.UUID, after int64) {
+    if id, err := strconv.ParseInt(ctx.GetHeader("Last-Event-ID"), 10, 64); err == nil && id > after {
+        after = id
+    }
+    ctx.Header("Content-Type", "text/event-stream")
+    ctx.Header("Cache-Control", "no-cache")
+    ctx.Header("Connection", "keep-alive")
+    ctx.Header("X-Accel-Buffering", "no")
+    ctx.Status(200)
+
+    ticker := time.NewTicker(LogPollInterval)
+    defer ticker.Stop()
+    for {
+        deployment, err := persistence.getDeployment(deploymentId)
+        if err != nil {
+            fmt.Fprintf(ctx.Writer, "event: error\ndata: unable to retrieve deployment\n\n")
+            ctx.Writer.Flush()
+            return
+        }
+        lines, err := persistence.getDeploymentLogs(deploymentId, after, 0)
+        if err != nil {
+            fmt.Fprintf(ctx.Writer, "event: error\ndata: unable to retrieve deployment logs\n\n")
+            ctx.Writer.Flush()
+            return
+        }
+        for _, line := range(lines) {
+            body, _ := json.Marshal(&line)
+            fmt.Fprintf(ctx.Writer, "id: %d\nevent: log\ndata: %s\n\n", line.Sequence, body)
+            after = line.Sequence
+        }
+        if isFinishedDeployment(deployment.State) {
+            body, _ := json.Marshal(gin.H{ "state": deployment.State, "error": deployment.Error })
+            fmt.Fprintf(ctx.Writer, "event: end\ndata: %s\n\n", body)
+            ctx.Writer.Flush()
+            return
+        }
+        ctx.Writer.Flush()
+
+        select {
+        case <-ctx.Request.Context().Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// function used to determine if deployment has finished
+func isFinishedDeployment(state string) bool {
+    return state == DeploymentSucceeded || state == DeploymentFailed || state == DeploymentSkipped
+}
@@ -2,9 +2,10 @@ package api
 
 import (
     "fmt"
-    "errors"
     "strings"
+    "path"
     "bytes"
+    "io"
     "encoding/json"
     "net/http"
     "io/ioutil"
@@ -28,48 +29,324 @@ func isValidGitRepo(repo string) bool {
     return true
 }
 
+// define error returned when the Github API responds with an unexpected status code
+type GitAPIError struct {
+    StatusCode int
+    Message    string
+}
+
+func (err *GitAPIError) Error() string {
+    return fmt.Sprintf("%s: API returned code %d", err.Message, err.StatusCode)
+}
+
+// function used to check if an error was caused by an invalid request to
+// the git server, such as revoked tokens or unknown repos. all other errors
+// are caused by the git server being unreachable or failing to respond
+func isGitClientError(err error) bool {
+    apiErr, ok := err.(*GitAPIError)
+    return ok && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+}
+
+// helper function used to send authenticated requests to the Github API
+func sendGitRequest(method, url, owner, token string, body io.Reader) (*http.Response, error) {
+    // create instance of HTTP Client and add required headers
+    client := &http.Client{}
+    request, err := http.NewRequest(method, url, body)
+    if err != nil {
+        return nil, err
+    }
+    // format request with headers and set basic auth with given token
+    request.Header.Add("accept", "application/vnd.github.v3+json")
+    request.SetBasicAuth(owner, token)
+    return client.Do(request)
+}
+
 // function used to create new git webhook when request is made
-func createGitWebHook(owner, repo, token string) (NewGitHookRequest, error) {
+func createGitWebHook(owner, repo, token string) (GitHookMeta, error) {
     // create new git hook request object
     requestBody := NewGitHookRequest{
         Active: true,
-        Events: []string{ "push" },
+        Events: []string{ "push", "create", "release" },
         Name: "web",
         Config: getGitHookConfig(),
     }
+    meta := GitHookMeta{ NewGitHookRequest: requestBody, RepoOwner: owner, RepoName: repo }
     requestBytes, _ := json.Marshal(&requestBody)
 
-    // create instance of HTTP Client and add required headers
-    client := &http.Client{}
     log.Debug(fmt.Sprintf("creating new hook for user %s with repo %s", owner, repo))
     url := fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks", owner, repo)
 
-    // format request with headers and set basic auth with given token
-    request, err := http.NewRequest("POST", url, bytes.NewReader(requestBytes))
-    request.Header.Add("accept", "application/vnd.github.v3+json")
-    request.SetBasicAuth(owner, token)
-
     // make request to Github API to create new git hook
-    resp, err := client.Do(request)
+    resp, err := sendGitRequest("POST", url, owner, token, bytes.NewReader(requestBytes))
     if err != nil {
         log.Error(fmt.Errorf("unable to great new git hook: %v", err))
-        return requestBody, err
+        return meta, err
     }
     defer resp.Body.Close()
 
     // parse request body if status is not 200 and return error
+    body, _ := ioutil.ReadAll(resp.Body)
     if resp.StatusCode != 200 && resp.StatusCode != 201 {
-        body, _ := ioutil.ReadAll(resp.Body)
         log.Error(fmt.Sprintf("unable to create git hook: API returned code %d and body %s", resp.StatusCode, body))
-        return requestBody, errors.New("unable to create new Githook")
+        return meta, &GitAPIError{ StatusCode: resp.StatusCode, Message: "unable to create new Githook" }
+    }
+
+    // parse ID of hook from response. hook IDs are required to remove hooks
+    var hook GitHookResponse
+    if err := json.Unmarshal(body, &hook); err != nil {
+        log.Error(fmt.Errorf("unable to parse git hook response: %v", err))
+        return meta, err
+    }
+    meta.GitHookId = hook.Id
+    return meta, nil
+}
+
+// function used to retrieve the IDs of all git hooks on a repo
+// that point to the go-get-git webhook URL
+func findGitWebHooks(owner, repo, token string) ([]int64, error) {
+    log.Debug(fmt.Sprintf("retrieving hooks for user %s with repo %s", owner, repo))
+    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks", owner, repo)
+
+    resp, err := sendGitRequest("GET", url, owner, token, nil)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve git hooks: %v", err))
+        return nil, err
     }
-    return requestBody, nil
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        log.Error(fmt.Sprintf("unable to retrieve git hooks: API returned code %d and body %s", resp.StatusCode, body))
+        return nil, &GitAPIError{ StatusCode: resp.StatusCode, Message: "unable to retrieve Githooks" }
+    }
+
+    var hooks []GitHookResponse
+    if err := json.Unmarshal(body, &hooks); err != nil {
+        log.Error(fmt.Errorf("unable to parse git hooks response: %v", err))
+        return nil, err
+    }
+    // filter out any hooks that do not point to the go-get-git service
+    hookIds := []int64{}
+    for _, hook := range(hooks) {
+        if hook.Config.Url == GitHookUrl {
+            hookIds = append(hookIds, hook.Id)
+        }
+    }
+    return hookIds, nil
 }
 
-// function used check if git events are pushes to master branch
-func isMasterPushEvent(e *github.PushEvent) bool {
-    if e.Ref != nil {
-        return strings.HasPrefix(*e.Ref, "refs/heads/") && strings.HasSuffix(*e.Ref, "/master")
+// function used to remove git webhook from git server. Note that
+// hooks that no longer exist are treated as successfully removed
+func deleteGitWebHook(owner, repo, token string, hookId int64) error {
+    log.Debug(fmt.Sprintf("removing hook %d for user %s with repo %s", hookId, owner, repo))
+    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/hooks/%d", owner, repo, hookId)
+
+    resp, err := sendGitRequest("DELETE", url, owner, token, nil)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to remove git hook: %v", err))
+        return err
     }
-    return false
+    defer resp.Body.Close()
+
+    if resp.StatusCode != 204 && resp.StatusCode != 404 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        log.Error(fmt.Sprintf("unable to remove git hook: API returned code %d and body %s", resp.StatusCode, body))
+        return &GitAPIError{ StatusCode: resp.StatusCode, Message: "unable to remove Githook" }
+    }
+    return nil
+}
+
+// function used to remove all go-get-git hooks belonging to a repo
+// entry from the git server. hooks created before hook IDs were stored
+// are resolved by listing the hooks on the repo
+func deleteEntryWebHooks(entry GitRepoEntry, hooks []GitHookEntry) error {
+    hookIds := []int64{}
+    for _, hook := range(hooks) {
+        meta, err := parseHookMeta(hook)
+        if err != nil {
+            return err
+        }
+        if meta.GitHookId == 0 {
+            hookIds = nil
+            break
+        }
+        hookIds = append(hookIds, meta.GitHookId)
+    }
+    // fallback to listing hooks on the repo if any IDs are missing
+    if hookIds == nil {
+        ids, err := findGitWebHooks(entry.RepoOwner, entry.RepoName, entry.AccessToken)
+        if err != nil {
+            return err
+        }
+        hookIds = ids
+    }
+
+    for _, hookId := range(hookIds) {
+        if err := deleteGitWebHook(entry.RepoOwner, entry.RepoName, entry.AccessToken, hookId); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// function used to re-create the git hooks of a repo entry after the
+// owner, name, access token or deploy trigger of the repo have been
+// changed, so that the hook subscribes to the current events. The new
+// hook is created as a saga step that is compensated by removing the
+// hook, and the hook entries are replaced in the given transaction. the
+// previous hooks are only removed by the returned cleanup function once
+// the transaction has been committed. Note that failures to remove
+// previous hooks are logged but do not fail the update, since tokens
+// are commonly changed after the previous token has been revoked
+func resyncEntryWebHooks(tx Persistence, s *saga, previous, updated GitRepoEntry) (func(), error) {
+    hooks, _, err := tx.getAllHookEntriesByEntryId(updated.EntryId, ListOptions{})
+    if err != nil {
+        return nil, err
+    }
+    var meta GitHookMeta
+    err = s.run("create_git_hook", func() error {
+        hook, err := createGitWebHook(updated.RepoOwner, updated.RepoName, updated.AccessToken)
+        meta = hook
+        return err
+    }, func() error {
+        return deleteGitWebHook(updated.RepoOwner, updated.RepoName, updated.AccessToken, meta.GitHookId)
+    })
+    if err != nil {
+        return nil, err
+    }
+    for _, hook := range(hooks) {
+        if err := tx.deleteHookEntry(hook.HookId); err != nil {
+            return nil, err
+        }
+    }
+    if _, err := tx.createHookEntry(updated.EntryId, meta); err != nil {
+        return nil, err
+    }
+
+    // attempt to remove previous hooks with previous and updated tokens
+    return func() {
+        if err := deleteEntryWebHooks(previous, hooks); err != nil {
+            previous.AccessToken = updated.AccessToken
+            if err := deleteEntryWebHooks(previous, hooks); err != nil {
+                log.Warn(fmt.Sprintf("unable to remove previous git hooks for entry %s: %v", updated.EntryId, err))
+            }
+        }
+    }, nil
+}
+
+// function used to register public key as a read-only deploy key on a repo
+func createGitDeployKey(owner, repo, token, title, publicKey string) (int64, error) {
+    requestBytes, _ := json.Marshal(&NewGitDeployKeyRequest{ Title: title, Key: publicKey, ReadOnly: true })
+
+    log.Debug(fmt.Sprintf("creating new deploy key for user %s with repo %s", owner, repo))
+    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/keys", owner, repo)
+
+    resp, err := sendGitRequest("POST", url, owner, token, bytes.NewReader(requestBytes))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to create new deploy key: %v", err))
+        return 0, err
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 201 {
+        log.Error(fmt.Sprintf("unable to create deploy key: API returned code %d and body %s", resp.StatusCode, body))
+        return 0, &GitAPIError{ StatusCode: resp.StatusCode, Message: "unable to create new deploy key" }
+    }
+    var key GitDeployKeyResponse
+    if err := json.Unmarshal(body, &key); err != nil {
+        log.Error(fmt.Errorf("unable to parse deploy key response: %v", err))
+        return 0, err
+    }
+    return key.Id, nil
+}
+
+// function used to remove deploy key from git server. Note that keys
+// that no longer exist are treated as successfully removed
+func deleteGitDeployKey(owner, repo, token string, keyId int64) error {
+    log.Debug(fmt.Sprintf("removing deploy key %d for user %s with repo %s", keyId, owner, repo))
+    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/keys/%d", owner, repo, keyId)
+
+    resp, err := sendGitRequest("DELETE", url, owner, token, nil)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to remove deploy key: %v", err))
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != 204 && resp.StatusCode != 404 {
+        body, _ := ioutil.ReadAll(resp.Body)
+        log.Error(fmt.Sprintf("unable to remove deploy key: API returned code %d and body %s", resp.StatusCode, body))
+        return &GitAPIError{ StatusCode: resp.StatusCode, Message: "unable to remove deploy key" }
+    }
+    return nil
+}
+
+// function used to retrieve the SHA of the commit that a branch or tag
+// points to. annotated tags are resolved to the commit they point to
+func getGitCommitSha(owner, repo, token, ref string) (string, error) {
+    log.Debug(fmt.Sprintf("retrieving commit of ref %s for user %s with repo %s", ref, owner, repo))
+    url := fmt.Sprintf("https://api.github.com/repos/%s/%s/commits/%s", owner, repo, ref)
+
+    resp, err := sendGitRequest("GET", url, owner, token, nil)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve commit: %v", err))
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        log.Error(fmt.Sprintf("unable to retrieve commit: API returned code %d and body %s", resp.StatusCode, body))
+        return "", &GitAPIError{ StatusCode: resp.StatusCode, Message: "unable to retrieve commit" }
+    }
+    var commit GitCommitResponse
+    if err := json.Unmarshal(body, &commit); err != nil {
+        log.Error(fmt.Errorf("unable to parse commit response: %v", err))
+        return "", err
+    }
+    return commit.Sha, nil
+}
+
+// function used to parse the metadata stored with a hook entry
+func parseHookMeta(hook GitHookEntry) (GitHookMeta, error) {
+    var meta GitHookMeta
+    body, err := json.Marshal(hook.Meta)
+    if err != nil {
+        return meta, err
+    }
+    err = json.Unmarshal(body, &meta)
+    return meta, err
+}
+
+// function used to retrieve the name of the branch that a push
+// event was made to. false is returned for pushes to non-branch refs
+func getPushEventBranch(e *github.PushEvent) (string, bool) {
+    if e.Ref != nil && strings.HasPrefix(*e.Ref, "refs/heads/") {
+        return strings.TrimPrefix(*e.Ref, "refs/heads/"), true
+    }
+    return "", false
+}
+
+// function used to retrieve the name of the tag that a push event
+// was made to. false is returned for pushes to non-tag refs
+func getPushEventTag(e *github.PushEvent) (string, bool) {
+    if e.Ref != nil && strings.HasPrefix(*e.Ref, "refs/tags/") {
+        return strings.TrimPrefix(*e.Ref, "refs/tags/"), true
+    }
+    return "", false
+}
+
+// function used to check if a branch or tag name matches the tracked
+// branch or tag pattern of an entry. patterns can be exact names or glob
+// patterns such as release/*. Note that wildcards do not match the '/' separator
+func matchesRefPattern(pattern, name string) bool {
+    matched, err := path.Match(pattern, name)
+    return err == nil && matched
+}
+
+// function used to check if pattern is a valid ref name or glob pattern
+func isValidRefPattern(pattern string) bool {
+    _, err := path.Match(pattern, "")
+    return len(pattern) > 0 && err == nil
 }
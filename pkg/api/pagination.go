@@ -0,0 +1,201 @@
+package api
+
+import (
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+    "encoding/base64"
+    "encoding/json"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+var (
+    InvalidListOptionsError = errors.New("invalid list options")
+    DefaultPageSize = 50
+    MaxPageSize = 500
+    // define page sizes of build logs and how often followed logs are polled
+    DefaultLogPageSize = 1000
+    MaxLogPageSize = 10000
+    LogPollInterval = time.Second
+)
+
+// define set of columns that list queries can be sorted by
+var sortableColumns = map[string]bool{ "created_at": true, "repo_url": true }
+
+// struct used to store pagination, filtering and sorting options
+// of list queries. Note that a Limit of 0 disables pagination
+type ListOptions struct {
+    Limit         int
+    Cursor        *ListCursor
+    Uid           string
+    RepoUrl       string
+    CreatedAfter  *time.Time
+    CreatedBefore *time.Time
+    SortBy        string
+    Descending    bool
+}
+
+// struct used to store position of last returned row. cursors are
+// encoded into opaque base64 strings before being returned to users
+type ListCursor struct {
+    SortBy string    `json:"s"`
+    Value  string    `json:"v"`
+    Id     uuid.UUID `json:"i"`
+}
+
+// struct used to store names of the columns that list options are applied to
+type listColumns struct {
+    Id        string
+    Uid       string
+    RepoUrl   string
+    CreatedAt string
+}
+
+// function used to parse list options from query parameters
+func parseListOptions(ctx *gin.Context) (ListOptions, error) {
+    options := ListOptions{ Limit: DefaultPageSize, SortBy: "created_at", Descending: true }
+    if limit := ctx.Query("limit"); len(limit) > 0 {
+        value, err := strconv.Atoi(limit)
+        if err != nil || value < 1 || value > MaxPageSize {
+            return options, InvalidListOptionsError
+        }
+        options.Limit = value
+    }
+    if sort := ctx.Query("sort"); len(sort) > 0 {
+        if !sortableColumns[sort] {
+            return options, InvalidListOptionsError
+        }
+        options.SortBy = sort
+    }
+    switch ctx.DefaultQuery("order", "desc") {
+    case "asc":
+        options.Descending = false
+    case "desc":
+        options.Descending = true
+    default:
+        return options, InvalidListOptionsError
+    }
+
+    options.Uid = ctx.Query("uid")
+    options.RepoUrl = ctx.Query("repo_url")
+    for param, target := range(map[string]**time.Time{ "created_after": &options.CreatedAfter, "created_before": &options.CreatedBefore }) {
+        if value := ctx.Query(param); len(value) > 0 {
+            timestamp, err := time.Parse(time.RFC3339, value)
+            if err != nil {
+                return options, InvalidListOptionsError
+            }
+            *target = &timestamp
+        }
+    }
+
+    if cursor := ctx.Query("cursor"); len(cursor) > 0 {
+        decoded, err := decodeCursor(cursor)
+        // cursors are only valid for the sort column that they were generated with
+        if err != nil || decoded.SortBy != options.SortBy {
+            return options, InvalidListOptionsError
+        }
+        options.Cursor = &decoded
+    }
+    return options, nil
+}
+
+// function used to encode cursor into opaque string
+func encodeCursor(cursor ListCursor) string {
+    body, _ := json.Marshal(&cursor)
+    return base64.RawURLEncoding.EncodeToString(body)
+}
+
+// function used to decode cursor from opaque string
+func decodeCursor(value string) (ListCursor, error) {
+    var cursor ListCursor
+    body, err := base64.RawURLEncoding.DecodeString(value)
+    if err != nil {
+        return cursor, err
+    }
+    err = json.Unmarshal(body, &cursor)
+    return cursor, err
+}
+
+// function used to generate cursor pointing at a given row
+func newCursor(options ListOptions, id uuid.UUID, repoUrl string, createdAt time.Time) string {
+    cursor := ListCursor{ SortBy: options.SortBy, Id: id }
+    switch options.SortBy {
+    case "repo_url":
+        cursor.Value = repoUrl
+    default:
+        cursor.Value = createdAt.Format(time.RFC3339Nano)
+    }
+    return encodeCursor(cursor)
+}
+
+// function used to apply list options to a SELECT query. filters are
+// appended as WHERE clauses, and the query is sorted by the sort column
+// with the ID column used as a tie-breaker for keyset pagination. Note
+// that one additional row is requested to determine if there are more pages
+func (options ListOptions) apply(query string, columns listColumns, args []interface{}) (string, []interface{}, error) {
+    conditions := []string{}
+    addCondition := func(condition string, value interface{}) {
+        args = append(args, value)
+        conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+    }
+
+    if len(options.Uid) > 0 {
+        addCondition(columns.Uid + " = $%d", options.Uid)
+    }
+    if len(options.RepoUrl) > 0 {
+        addCondition("strpos(" + columns.RepoUrl + ", $%d) > 0", options.RepoUrl)
+    }
+    if options.CreatedAfter != nil {
+        addCondition(columns.CreatedAt + " >= $%d", *options.CreatedAfter)
+    }
+    if options.CreatedBefore != nil {
+        addCondition(columns.CreatedAt + " < $%d", *options.CreatedBefore)
+    }
+
+    sortColumn, comparison, order := columns.CreatedAt, ">", "ASC"
+    if options.SortBy == "repo_url" {
+        sortColumn = columns.RepoUrl
+    }
+    if options.Descending {
+        comparison, order = "<", "DESC"
+    }
+
+    if options.Cursor != nil {
+        var value interface{} = options.Cursor.Value
+        if options.SortBy == "created_at" {
+            timestamp, err := time.Parse(time.RFC3339Nano, options.Cursor.Value)
+            if err != nil {
+                return query, args, InvalidListOptionsError
+            }
+            value = timestamp
+        }
+        args = append(args, value, options.Cursor.Id)
+        conditions = append(conditions, fmt.Sprintf("(%s, %s) %s ($%d, $%d)", sortColumn, columns.Id, comparison, len(args) - 1, len(args)))
+    }
+
+    if len(conditions) > 0 {
+        joiner := " WHERE "
+        if strings.Contains(strings.ToUpper(query), " WHERE ") {
+            joiner = " AND "
+        }
+        query += joiner + strings.Join(conditions, " AND ")
+    }
+    query += fmt.Sprintf(" ORDER BY %s %s, %s %s", sortColumn, order, columns.Id, order)
+    if options.Limit > 0 {
+        query += fmt.Sprintf(" LIMIT %d", options.Limit + 1)
+    }
+    return query, args, nil
+}
+
+// function used to send a page of results with the cursor used to
+// retrieve the next page. cursors are null on the last page
+func listResponse(ctx *gin.Context, payload interface{}, cursor string) {
+    var nextCursor interface{}
+    if len(cursor) > 0 {
+        nextCursor = cursor
+    }
+    ctx.JSON(200, gin.H{ "http_code": 200, "success": true, "payload": payload, "next_cursor": nextCursor })
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+    "time"
+    "testing"
+    "net/http/httptest"
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// helper function used to create gin context with given query string
+func testContext(query string) *gin.Context {
+    gin.SetMode(gin.TestMode)
+    ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+    ctx.Request = httptest.NewRequest("GET", "/?" + query, nil)
+    return ctx
+}
+
+func TestParseListOptions(t *testing.T) {
+    createdCursor := newCursor(ListOptions{ SortBy: "created_at" }, uuid.New(), "", time.Now())
+    repoCursor := newCursor(ListOptions{ SortBy: "repo_url" }, uuid.New(), "https://github.com/user/repo", time.Now())
+
+    tests := []struct {
+        name       string
+        query      string
+        limit      int
+        sortBy     string
+        descending bool
+        cursor     bool
+        err        error
+    }{
+        { "sort by repo URL with cursor", "sort=repo_url&cursor=" + repoCursor, DefaultPageSize, "repo_url", true, true, nil },
+        { "limit above maximum", "limit=501", 0, "", false, false, InvalidListOptionsError },
+        { "invalid cursor", "cursor=invalid", 0, "", false, false, InvalidListOptionsError },
+        { "cursor of other sort column", "sort=repo_url&cursor=" + createdCursor, 0, "", false, false, InvalidListOptionsError },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            options, err := parseListOptions(testContext(test.query))
+            if err != test.err {
+                t.Fatalf("expected error %v, got %v", test.err, err)
+            }
+            if err != nil {
+                return
+            }
+            if options.Limit != test.limit || options.SortBy != test.sortBy || options.Descending != test.descending || (options.Cursor != nil) != test.cursor {
+                t.Errorf("received unexpected options %+v", options)
+            }
+        })
+    }
+}
+
+func TestListOptionsApply(t *testing.T) {
+    columns := listColumns{ Id: "r.entry_id", Uid: "r.uid", RepoUrl: "r.repo_url", CreatedAt: "r.created_at" }
+    cursor := ListCursor{ SortBy: "repo_url", Value: "https://github.com/user/repo", Id: uuid.New() }
+
+    tests := []struct {
+        name    string
+        base    string
+        args    []interface{}
+        options ListOptions
+        query   string
+        count   int
+    }{
+        {
+            "filters with limit",
+            "SELECT * FROM repo_entries r",
+            nil,
+            ListOptions{ Limit: 10, Uid: "user", RepoUrl: "repo", SortBy: "created_at", Descending: true },
+            "SELECT * FROM repo_entries r WHERE r.uid = $1 AND strpos(r.repo_url, $2) > 0 ORDER BY r.created_at DESC, r.entry_id DESC LIMIT 11",
+            2,
+        },
+        {
+            "cursor appended to existing condition",
+            "SELECT * FROM repo_entries r WHERE r.entry_id = $1",
+            []interface{}{ uuid.New() },
+            ListOptions{ Limit: 5, SortBy: "repo_url", Cursor: &cursor },
+            "SELECT * FROM repo_entries r WHERE r.entry_id = $1 AND (r.repo_url, r.entry_id) > ($2, $3) ORDER BY r.repo_url ASC, r.entry_id ASC LIMIT 6",
+            3,
+        },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            query, args, err := test.options.apply(test.base, columns, test.args)
+            if err != nil {
+                t.Fatalf("unable to apply options: %v", err)
+            }
+            if query != test.query || len(args) != test.count {
+                t.Errorf("expected query '%s' with %d arg(s), got '%s' with %d arg(s)", test.query, test.count, query, len(args))
+            }
+        })
+    }
+}
+
+func TestListOptionsApplyInvalidCursor(t *testing.T) {
+    options := ListOptions{ SortBy: "created_at", Cursor: &ListCursor{ SortBy: "created_at", Value: "invalid", Id: uuid.New() } }
+    if _, _, err := options.apply("SELECT * FROM repo_entries r", listColumns{}, nil); err != InvalidListOptionsError {
+        t.Errorf("expected invalid list options error, got %v", err)
+    }
+}
@@ -5,15 +5,28 @@ import (
     "time"
     "context"
     "encoding/json"
+    "github.com/PSauerborn/go-get-git/pkg/events"
     "github.com/google/uuid"
+    "github.com/jackc/pgconn"
+    "github.com/jackc/pgx/v4"
     "github.com/jackc/pgx/v4/pgxpool"
     log "github.com/sirupsen/logrus"
 )
 
 var persistence *Persistence
 
+// interface implemented by both connection pools and transactions,
+// allowing persistence functions to be executed in a transaction
+type dbConn interface {
+    Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+    Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+    QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+    SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
 type Persistence struct {
-    conn *pgxpool.Pool
+    conn dbConn
+    pool *pgxpool.Pool
 }
 
 // function used to connect postgres connection
@@ -25,15 +38,43 @@ func ConnectPersistence() {
     }
     log.Info("successfully connected to postgres")
     // connect persistence and assign to persistence var
-    persistence = &Persistence{db}
+    persistence = &Persistence{db, db}
+}
+
+// function used to execute a set of persistence functions in a single
+// transaction. the transaction is rolled back if the given function
+// returns an error, and committed otherwise
+func (db Persistence) withTransaction(fn func(tx Persistence) error) error {
+    tx, err := db.pool.Begin(context.Background())
+    if err != nil {
+        log.Error(fmt.Errorf("unable to start transaction: %v", err))
+        return err
+    }
+    if err := fn(Persistence{tx, db.pool}); err != nil {
+        if rollbackErr := tx.Rollback(context.Background()); rollbackErr != nil {
+            log.Error(fmt.Errorf("unable to rollback transaction: %v", rollbackErr))
+        }
+        return err
+    }
+    if err := tx.Commit(context.Background()); err != nil {
+        log.Error(fmt.Errorf("unable to commit transaction: %v", err))
+        return err
+    }
+    return nil
 }
 
 // function used to create new repository entry in database
 func (db Persistence) createRepoEntry(user string, body NewRegistryEntry) (uuid.UUID, error) {
-    log.Debug(fmt.Sprintf("creating new registry entry %+v", body))
+    log.Debug(fmt.Sprintf("creating new registry entry %+v", body.redacted()))
     entryId := uuid.New()
+    // encrypt access token before storing in database
+    token, err := keyring.Encrypt(body.RepoAccessToken)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to encrypt access token: %v", err))
+        return entryId, err
+    }
     // insert entry into database
-    _, err := db.conn.Exec(context.Background(), "INSERT INTO repo_entries(entry_id,uid,repo_url,access_token) VALUES($1,$2,$3,$4)", entryId, user, body.RepoUrl, body.RepoAccessToken)
+    _, err = db.conn.Exec(context.Background(), "INSERT INTO repo_entries(entry_id,uid,repo_url,repo_name,repo_owner,tracked_branch,deploy_trigger,tag_pattern,credential_type,builder,health_check_url,health_check_timeout,auto_rollback,target_node,access_token,token_fingerprint,token_updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,NOW())", entryId, user, body.RepoUrl, body.RepoName, body.RepoOwner, body.TrackedBranch, body.DeployTrigger, body.TagPattern, body.CredentialType, body.Builder, body.HealthCheckUrl, body.HealthCheckTimeout, body.AutoRollback, body.TargetNode, token, TokenFingerprint(body.RepoAccessToken))
     if err != nil {
         log.Error(fmt.Errorf("unable to insert values into users table: %v", err))
         return entryId, err
@@ -41,7 +82,7 @@ func (db Persistence) createRepoEntry(user string, body NewRegistryEntry) (uuid.
     return entryId, nil
 }
 
-func (db Persistence) createHookEntry(entryId uuid.UUID, config NewGitHookRequest) (uuid.UUID, error) {
+func (db Persistence) createHookEntry(entryId uuid.UUID, config GitHookMeta) (uuid.UUID, error) {
     log.Debug(fmt.Sprintf("creating new hook entry for entry %s", entryId))
     hookId := uuid.New()
 
@@ -55,80 +96,248 @@ func (db Persistence) createHookEntry(entryId uuid.UUID, config NewGitHookReques
     return hookId, nil
 }
 
+// helper interface used to scan both single rows and row iterators
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// column selection used whenever repo entries are retrieved. note that
+// the order of the columns must match the order of the scanRepoEntry function
+const repoEntryColumns = "entry_id,uid,repo_url,repo_name,repo_owner,tracked_branch,deploy_trigger,tag_pattern,credential_type,builder,health_check_url,health_check_timeout,auto_rollback,target_node,access_token,token_fingerprint,token_updated_at,created_at"
+
+// helper function used to scan repo entry into GitRepoEntry struct.
+// access tokens are decrypted after being read from the database
+func scanRepoEntry(row rowScanner) (GitRepoEntry, error) {
+    var (entry GitRepoEntry; token string)
+    err := row.Scan(&entry.EntryId, &entry.Uid, &entry.RepoUrl, &entry.RepoName, &entry.RepoOwner, &entry.TrackedBranch, &entry.DeployTrigger, &entry.TagPattern, &entry.CredentialType, &entry.Builder, &entry.HealthCheckUrl, &entry.HealthCheckTimeout, &entry.AutoRollback, &entry.TargetNode, &token, &entry.TokenFingerprint, &entry.TokenUpdatedAt, &entry.CreatedAt)
+    if err != nil {
+        return entry, err
+    }
+    entry.AccessToken, err = keyring.Decrypt(token)
+    return entry, err
+}
+
+// helper function used to scan a set of rows into GitRepoEntry structs
+func scanRepoEntries(rows pgx.Rows) []GitRepoEntry {
+    defer rows.Close()
+    values := []GitRepoEntry{}
+    // iterate over data results and format into GitRepoEntry{} structs
+    for rows.Next() {
+        entry, err := scanRepoEntry(rows)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to process row: %v", err))
+        } else {
+            values = append(values, entry)
+        }
+    }
+    return values
+}
+
 func (db Persistence) getRepoEntry(entryId uuid.UUID) (GitRepoEntry, error) {
     log.Debug(fmt.Sprintf("retrieving repo entry with ID %s", entryId))
-    var (uid, repoUrl, accessToken string; createdAt time.Time)
     // get results from database and scan into variables
-    results := db.conn.QueryRow(context.Background(), "SELECT entry_id,uid,repo_url,access_token,created_at FROM repo_entries WHERE entry_id=$1", entryId)
-    err := results.Scan(&uid, &repoUrl, &accessToken, &createdAt)
+    results := db.conn.QueryRow(context.Background(), "SELECT " + repoEntryColumns + " FROM repo_entries WHERE entry_id=$1", entryId)
+    entry, err := scanRepoEntry(results)
     if err != nil {
         log.Error(fmt.Errorf("unable to fetch repo entries from database: %v", err))
         return GitRepoEntry{}, err
     }
-    return GitRepoEntry{ EntryId: entryId, Uid: uid, RepoUrl: repoUrl, AccessToken: accessToken, CreatedAt: createdAt }, nil
+    return entry, nil
 }
 
 func (db Persistence) getRepoEntryByRepoUrl(url string) (GitRepoEntry, error) {
     log.Debug(fmt.Sprintf("retrieving repo entry for url %s", url))
-    var (entryId uuid.UUID; uid, repoUrl, accessToken string; createdAt time.Time)
     // get results from database and scan into variables
-    results := db.conn.QueryRow(context.Background(), "SELECT entry_id,uid,repo_url,access_token,created_at FROM repo_entries WHERE repo_url=$1", url)
-    err := results.Scan(&entryId, &uid, &repoUrl, &accessToken, &createdAt)
+    results := db.conn.QueryRow(context.Background(), "SELECT " + repoEntryColumns + " FROM repo_entries WHERE repo_url=$1", url)
+    entry, err := scanRepoEntry(results)
     if err != nil {
         log.Error(fmt.Errorf("unable to fetch repo entries from database: %v", err))
         return GitRepoEntry{}, err
     }
-    return GitRepoEntry{ EntryId: entryId, Uid: uid, RepoUrl: repoUrl, AccessToken: accessToken, CreatedAt: createdAt }, nil
+    return entry, nil
 }
 
-func (db Persistence) getAllRepoEntries() ([]GitRepoEntry, error) {
-    log.Debug("retrieving all repo entries")
-    values := []GitRepoEntry{}
+// function used to retrieve a page of repo entries matching the given
+// list options. the cursor pointing to the next page is returned if
+// there are more results
+func (db Persistence) getAllRepoEntries(options ListOptions) ([]GitRepoEntry, string, error) {
+    log.Debug(fmt.Sprintf("retrieving repo entries with options %+v", options))
+    columns := listColumns{ Id: "entry_id", Uid: "uid", RepoUrl: "repo_url", CreatedAt: "created_at" }
+    query, args, err := options.apply("SELECT " + repoEntryColumns + " FROM repo_entries", columns, []interface{}{})
+    if err != nil {
+        return []GitRepoEntry{}, "", err
+    }
     // get results from database and scan into variables
-    rows, err := db.conn.Query(context.Background(), "SELECT entry_id,uid,repo_url,access_token,created_at FROM repo_entries")
+    rows, err := db.conn.Query(context.Background(), query, args...)
     if err != nil {
         log.Error(fmt.Errorf("unable to retrieve repo entries: %v", err))
-        return values, err
+        return []GitRepoEntry{}, "", err
     }
+    values := scanRepoEntries(rows)
 
-    // iterate over data results and format into GitRepoEntry{} structs
+    // trim additional row used to determine if there are more pages
+    if options.Limit > 0 && len(values) > options.Limit {
+        values = values[:options.Limit]
+        last := values[len(values) - 1]
+        return values, newCursor(options, last.EntryId, last.RepoUrl, last.CreatedAt), nil
+    }
+    return values, "", nil
+}
+
+func (db Persistence) updateRepoEntry(entry GitRepoEntry) error {
+    log.Debug(fmt.Sprintf("updating repo entry with ID %s", entry.EntryId))
+    // encrypt access token before storing in database
+    token, err := keyring.Encrypt(entry.AccessToken)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to encrypt access token: %v", err))
+        return err
+    }
+    // note that token timestamps are only updated if the token has changed
+    _, err = db.conn.Exec(context.Background(), "UPDATE repo_entries SET repo_url=$2,repo_name=$3,repo_owner=$4,tracked_branch=$5,deploy_trigger=$6,tag_pattern=$7,credential_type=$8,builder=$9,health_check_url=$10,health_check_timeout=$11,auto_rollback=$12,target_node=$13,access_token=$14,token_fingerprint=$15,token_updated_at=CASE WHEN token_fingerprint=$15 THEN token_updated_at ELSE NOW() END WHERE entry_id=$1", entry.EntryId, entry.RepoUrl, entry.RepoName, entry.RepoOwner, entry.TrackedBranch, entry.DeployTrigger, entry.TagPattern, entry.CredentialType, entry.Builder, entry.HealthCheckUrl, entry.HealthCheckTimeout, entry.AutoRollback, entry.TargetNode, token, TokenFingerprint(entry.AccessToken))
+    if err != nil {
+        log.Error(fmt.Errorf("unable to update repo entry %s: %v", entry.EntryId, err))
+        return err
+    }
+    return nil
+}
+
+// function used to re-encrypt all access tokens that are stored in
+// plaintext or encrypted with a key other than the primary key. the
+// number of re-encrypted tokens is returned
+func (db Persistence) reencryptTokens() (int, error) {
+    log.Info(fmt.Sprintf("re-encrypting access tokens with primary key %s", keyring.Primary))
+    rows, err := db.conn.Query(context.Background(), "SELECT entry_id,access_token FROM repo_entries")
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve access tokens: %v", err))
+        return 0, err
+    }
+    // read all tokens before updating to release connection
+    tokens := map[uuid.UUID]string{}
     for rows.Next() {
-        var (entryId uuid.UUID; uid, repoUrl, accessToken string; createdAt time.Time)
-        err := rows.Scan(&entryId, &uid, &repoUrl, &accessToken, &createdAt)
+        var (entryId uuid.UUID; token string)
+        if err := rows.Scan(&entryId, &token); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        tokens[entryId] = token
+    }
+    rows.Close()
+
+    count := 0
+    for entryId, stored := range(tokens) {
+        if !keyring.NeedsRotation(stored) {
+            continue
+        }
+        token, err := keyring.Decrypt(stored)
         if err != nil {
-            log.Error(fmt.Errorf("unable to process row: %v", err))
-        } else {
-            // generate struct and append fo results
-            entry := GitRepoEntry{ EntryId: entryId, Uid: uid, RepoUrl: repoUrl, AccessToken: accessToken, CreatedAt: createdAt }
-            values = append(values, entry)
+            log.Error(fmt.Errorf("unable to decrypt access token for entry %s: %v", entryId, err))
+            return count, err
+        }
+        encrypted, err := keyring.Encrypt(token)
+        if err != nil {
+            return count, err
+        }
+        _, err = db.conn.Exec(context.Background(), "UPDATE repo_entries SET access_token=$2,token_fingerprint=$3 WHERE entry_id=$1", entryId, encrypted, TokenFingerprint(token))
+        if err != nil {
+            log.Error(fmt.Errorf("unable to update access token for entry %s: %v", entryId, err))
+            return count, err
         }
+        count++
     }
-    return values, nil
+    return count, nil
 }
 
-func (db Persistence) getUserRepoEntries(uid string) ([]GitRepoEntry, error) {
-    log.Debug(fmt.Sprintf("retrieving all repo entries for user %s", uid))
-    values := []GitRepoEntry{}
-    // get results from database and scan into variables
-    rows, err := db.conn.Query(context.Background(), "SELECT entry_id,uid,repo_url,access_token,created_at FROM repo_entries WHERE uid=$1", uid)
+// function used to re-encrypt all private keys of deploy keys that are
+// encrypted with a key other than the primary key. the number of
+// re-encrypted keys is returned
+func (db Persistence) reencryptDeployKeys() (int, error) {
+    log.Info(fmt.Sprintf("re-encrypting deploy keys with primary key %s", keyring.Primary))
+    rows, err := db.conn.Query(context.Background(), "SELECT entry_id,private_key FROM deploy_keys")
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve repo entries: %v", err))
-        return values, err
+        log.Error(fmt.Errorf("unable to retrieve deploy keys: %v", err))
+        return 0, err
     }
-
-    // iterate over data results and format into GitRepoEntry{} structs
+    // read all keys before updating to release connection
+    keys := map[uuid.UUID]string{}
     for rows.Next() {
-        var (entryId uuid.UUID; uid, repoUrl, accessToken string; createdAt time.Time)
-        err := rows.Scan(&entryId, &uid, &repoUrl, &accessToken, &createdAt)
+        var (entryId uuid.UUID; key string)
+        if err := rows.Scan(&entryId, &key); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        keys[entryId] = key
+    }
+    rows.Close()
+
+    count := 0
+    for entryId, stored := range(keys) {
+        if !keyring.NeedsRotation(stored) {
+            continue
+        }
+        key, err := keyring.Decrypt(stored)
         if err != nil {
-            log.Error(fmt.Errorf("unable to process row: %v", err))
-        } else {
-            // generate struct and append fo results
-            entry := GitRepoEntry{ EntryId: entryId, Uid: uid, RepoUrl: repoUrl, AccessToken: accessToken, CreatedAt: createdAt }
-            values = append(values, entry)
+            log.Error(fmt.Errorf("unable to decrypt deploy key for entry %s: %v", entryId, err))
+            return count, err
+        }
+        encrypted, err := keyring.Encrypt(key)
+        if err != nil {
+            return count, err
+        }
+        _, err = db.conn.Exec(context.Background(), "UPDATE deploy_keys SET private_key=$2 WHERE entry_id=$1", entryId, encrypted)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to update deploy key for entry %s: %v", entryId, err))
+            return count, err
         }
+        count++
+    }
+    return count, nil
+}
+
+// function used to store deploy key of repo entry. private keys are
+// encrypted before being stored, and existing keys are replaced
+func (db Persistence) createDeployKey(entryId uuid.UUID, key DeployKey) error {
+    log.Debug(fmt.Sprintf("creating deploy key %s for entry %s", key.Fingerprint, entryId))
+    privateKey, err := keyring.Encrypt(key.PrivateKey)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to encrypt deploy key: %v", err))
+        return err
+    }
+    query := `INSERT INTO deploy_keys(entry_id,git_key_id,public_key,private_key,fingerprint) VALUES($1,$2,$3,$4,$5)
+        ON CONFLICT (entry_id) DO UPDATE SET git_key_id=$2,public_key=$3,private_key=$4,fingerprint=$5,created_at=NOW()`
+    _, err = db.conn.Exec(context.Background(), query, entryId, key.GitKeyId, key.PublicKey, privateKey, key.Fingerprint)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to insert values into deploy keys table: %v", err))
+        return err
+    }
+    return nil
+}
+
+// function used to retrieve deploy key of repo entry. private keys
+// are decrypted after being read from the database
+func (db Persistence) getDeployKey(entryId uuid.UUID) (DeployKey, error) {
+    log.Debug(fmt.Sprintf("retrieving deploy key for entry %s", entryId))
+    var (key DeployKey; privateKey string)
+    results := db.conn.QueryRow(context.Background(), "SELECT entry_id,git_key_id,public_key,private_key,fingerprint,created_at FROM deploy_keys WHERE entry_id=$1", entryId)
+    if err := results.Scan(&key.EntryId, &key.GitKeyId, &key.PublicKey, &privateKey, &key.Fingerprint, &key.CreatedAt); err != nil {
+        return DeployKey{}, err
+    }
+    decrypted, err := keyring.Decrypt(privateKey)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to decrypt deploy key for entry %s: %v", entryId, err))
+        return DeployKey{}, err
+    }
+    key.PrivateKey = decrypted
+    return key, nil
+}
+
+func (db Persistence) deleteDeployKey(entryId uuid.UUID) error {
+    log.Debug(fmt.Sprintf("deleting deploy key for entry %s", entryId))
+    _, err := db.conn.Exec(context.Background(), "DELETE FROM deploy_keys WHERE entry_id = $1", entryId)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to delete deploy key for entry %s: %v", entryId, err))
+        return err
     }
-    return values, nil
+    return nil
 }
 
 func (db Persistence) deleteRepoEntry(entryId uuid.UUID) error {
@@ -155,54 +364,59 @@ func (db Persistence) getHookEntry(hookId uuid.UUID) (GitHookEntry, error) {
     return GitHookEntry{ EntryId: entryId, HookId: hookId, CreatedAt: created, Meta: meta }, nil
 }
 
-func (db Persistence) getAllHookEntries() ([]GitHookEntry, error) {
-    log.Debug("retrieving all hook entries")
-    values := []GitHookEntry{}
-    // retrieve values from postgres server
-    rows, err := db.conn.Query(context.Background(), "SELECT entry_id,hook_id,created_at,meta FROM git_hooks")
-    if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve repo entries: %v", err))
-        return values, err
-    }
+// function used to retrieve a page of hook entries matching the given
+// list options. Note that uid and repo URL filters are applied to the
+// repo entries that the hooks belong to
+func (db Persistence) getAllHookEntries(options ListOptions) ([]GitHookEntry, string, error) {
+    return db.listHookEntries("SELECT h.entry_id,h.hook_id,h.created_at,h.meta,r.repo_url FROM git_hooks h JOIN repo_entries r ON r.entry_id = h.entry_id", []interface{}{}, options)
+}
 
-    // iterate over results and generate GitHookEntry{} structs
-    for rows.Next() {
-        var (entryId, hookId uuid.UUID; created time.Time; meta interface{})
-        err := rows.Scan(&entryId, &hookId, &created, &meta)
-        if err != nil {
-            log.Error(fmt.Errorf("unable to process row: %v", err))
-        } else {
-            // format entry into entry struct
-            entry := GitHookEntry{ EntryId: entryId, HookId: hookId, CreatedAt: created, Meta: meta }
-            values = append(values, entry)
-        }
-    }
-    return values, nil
+// function used to retrieve a page of hook entries that belong to a
+// given repo entry. all hooks are returned if the limit is set to 0
+func (db Persistence) getAllHookEntriesByEntryId(entryId uuid.UUID, options ListOptions) ([]GitHookEntry, string, error) {
+    return db.listHookEntries("SELECT h.entry_id,h.hook_id,h.created_at,h.meta,r.repo_url FROM git_hooks h JOIN repo_entries r ON r.entry_id = h.entry_id WHERE h.entry_id = $1", []interface{}{ entryId }, options)
 }
 
-func (db Persistence) getAllHookEntriesByEntryId(entryId uuid.UUID) ([]GitHookEntry, error) {
-    log.Debug("retrieving all hook entries")
+// helper function used to apply list options to hook queries and scan results
+func (db Persistence) listHookEntries(query string, args []interface{}, options ListOptions) ([]GitHookEntry, string, error) {
+    log.Debug(fmt.Sprintf("retrieving hook entries with options %+v", options))
     values := []GitHookEntry{}
+    columns := listColumns{ Id: "h.hook_id", Uid: "r.uid", RepoUrl: "r.repo_url", CreatedAt: "h.created_at" }
+    query, args, err := options.apply(query, columns, args)
+    if err != nil {
+        return values, "", err
+    }
     // retrieve values from postgres server
-    rows, err := db.conn.Query(context.Background(), "SELECT entry_id,hook_id,created_at,meta FROM git_hooks WHERE entry_id = $1", entryId)
+    rows, err := db.conn.Query(context.Background(), query, args...)
     if err != nil {
-        log.Error(fmt.Errorf("unable to retrieve repo entries: %v", err))
-        return values, err
+        log.Error(fmt.Errorf("unable to retrieve hook entries: %v", err))
+        return values, "", err
     }
+    defer rows.Close()
 
-    // iterate over results and generate GitHookEntry{} structs
+    // iterate over results and generate GitHookEntry{} structs. repo URLs
+    // are only retrieved to generate cursors when sorting by repo URL
+    repoUrls := []string{}
     for rows.Next() {
-        var (entryId, hookId uuid.UUID; created time.Time; meta interface{})
-        err := rows.Scan(&entryId, &hookId, &created, &meta)
+        var (entryId, hookId uuid.UUID; created time.Time; meta interface{}; repoUrl string)
+        err := rows.Scan(&entryId, &hookId, &created, &meta, &repoUrl)
         if err != nil {
             log.Error(fmt.Errorf("unable to process row: %v", err))
         } else {
             // format entry into entry struct
             entry := GitHookEntry{ EntryId: entryId, HookId: hookId, CreatedAt: created, Meta: meta }
             values = append(values, entry)
+            repoUrls = append(repoUrls, repoUrl)
         }
     }
-    return values, nil
+
+    // trim additional row used to determine if there are more pages
+    if options.Limit > 0 && len(values) > options.Limit {
+        values = values[:options.Limit]
+        last := values[len(values) - 1]
+        return values, newCursor(options, last.HookId, repoUrls[len(values) - 1], last.CreatedAt), nil
+    }
+    return values, "", nil
 }
 
 func (db Persistence) deleteHookEntry(hookId uuid.UUID) error {
@@ -238,4 +452,369 @@ func (db Persistence) createEntryDirectory(entryId uuid.UUID, applicationDirecto
         return err
     }
     return nil
-}
\ No newline at end of file
+}
+
+func (db Persistence) updateEntryDirectory(entryId uuid.UUID, applicationDirectory string) error {
+    log.Debug(fmt.Sprintf("updating application directory for entry %s to %s", entryId, applicationDirectory))
+    _, err := db.conn.Exec(context.Background(), "UPDATE application_directories SET application_directory=$2 WHERE entry_id=$1", entryId, applicationDirectory)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to update application directory for entry %s: %v", entryId, err))
+        return err
+    }
+    return nil
+}
+
+func (db Persistence) deleteEntryDirectory(entryId uuid.UUID) error {
+    log.Debug(fmt.Sprintf("deleting application directory for entry %s", entryId))
+    _, err := db.conn.Exec(context.Background(), "DELETE FROM application_directories WHERE entry_id = $1", entryId)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to delete application directory for entry %s: %v", entryId, err))
+        return err
+    }
+    return nil
+}
+
+// function used to remove repo entry along with its hook entries and
+// application directory in a single transaction, so that failures do not
+// leave orphaned rows. remaining rows are removed by cascading deletes
+func (db Persistence) removeRepoEntry(entryId uuid.UUID) error {
+    log.Debug(fmt.Sprintf("removing repo entry %s and related entries", entryId))
+    return db.withTransaction(func(tx Persistence) error {
+        _, err := tx.conn.Exec(context.Background(), "DELETE FROM git_hooks WHERE entry_id = $1", entryId)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to delete git hooks for entry %s: %v", entryId, err))
+            return err
+        }
+        if err := tx.deleteEntryDirectory(entryId); err != nil {
+            return err
+        }
+        return tx.deleteRepoEntry(entryId)
+    })
+}
+
+func (db Persistence) createRegistration(registration Registration) error {
+    log.Debug(fmt.Sprintf("creating new registration %s", registration.RegistrationId))
+    _, err := db.conn.Exec(context.Background(), "INSERT INTO registrations(registration_id,uid,repo_url,state,step) VALUES($1,$2,$3,$4,$5)", registration.RegistrationId, registration.Uid, registration.RepoUrl, registration.State, registration.Step)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to insert values into registrations table: %v", err))
+        return err
+    }
+    return nil
+}
+
+func (db Persistence) updateRegistration(registration Registration) error {
+    log.Debug(fmt.Sprintf("updating registration %s with state %s at step %s", registration.RegistrationId, registration.State, registration.Step))
+    _, err := db.conn.Exec(context.Background(), "UPDATE registrations SET entry_id=$2,state=$3,step=$4,error=$5,updated_at=NOW() WHERE registration_id=$1", registration.RegistrationId, registration.EntryId, registration.State, registration.Step, registration.Error)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to update registration %s: %v", registration.RegistrationId, err))
+        return err
+    }
+    return nil
+}
+
+func (db Persistence) getRegistration(registrationId uuid.UUID) (Registration, error) {
+    log.Debug(fmt.Sprintf("retrieving registration with ID %s", registrationId))
+    var registration Registration
+    results := db.conn.QueryRow(context.Background(), "SELECT registration_id,entry_id,uid,repo_url,state,step,error,created_at,updated_at FROM registrations WHERE registration_id=$1", registrationId)
+    err := results.Scan(&registration.RegistrationId, &registration.EntryId, &registration.Uid, &registration.RepoUrl, &registration.State, &registration.Step, &registration.Error, &registration.CreatedAt, &registration.UpdatedAt)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve registration %s: %v", registrationId, err))
+        return Registration{}, err
+    }
+    return registration, nil
+}
+
+// column selection used whenever deployments are retrieved. note that
+// the order of the columns must match the order of the scanDeployment function
+const deploymentColumns = "d.deployment_id,d.entry_id,d.commit_sha,d.commit_message,d.ref,d.trigger,d.state,d.triggered_by,d.error,d.failed_step,d.duration_ms,d.container_ids,d.created_at,d.started_at,d.finished_at"
+
+// helper function used to scan deployment into Deployment struct. any
+// additional columns selected after the deployment columns are scanned
+// into the extra destinations
+func scanDeployment(row rowScanner, extra ...interface{}) (Deployment, error) {
+    var deployment Deployment
+    dest := []interface{}{ &deployment.DeploymentId, &deployment.EntryId, &deployment.CommitSha, &deployment.CommitMessage, &deployment.Ref, &deployment.Trigger, &deployment.State, &deployment.TriggeredBy, &deployment.Error, &deployment.FailedStep, &deployment.DurationMs, &deployment.ContainerIds, &deployment.CreatedAt, &deployment.StartedAt, &deployment.FinishedAt }
+    err := row.Scan(append(dest, extra...)...)
+    return deployment, err
+}
+
+func (db Persistence) createDeployment(deployment Deployment) error {
+    log.Debug(fmt.Sprintf("creating new deployment %s for entry %s", deployment.DeploymentId, deployment.EntryId))
+    _, err := db.conn.Exec(context.Background(), "INSERT INTO deployments(deployment_id,entry_id,commit_sha,commit_message,ref,trigger,state,triggered_by) VALUES($1,$2,$3,$4,$5,$6,$7,$8)", deployment.DeploymentId, deployment.EntryId, deployment.CommitSha, deployment.CommitMessage, deployment.Ref, deployment.Trigger, deployment.State, deployment.TriggeredBy)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to insert values into deployments table: %v", err))
+        return err
+    }
+    return nil
+}
+
+// function used to update state of deployment. start and finish timestamps
+// are set when deployments enter the building and final states respectively.
+// deployments in a final state are not updated, since build events can be
+// redelivered or arrive late once the deployment has already been settled
+func (db Persistence) updateDeploymentState(deploymentId uuid.UUID, update deploymentUpdate) error {
+    log.Debug(fmt.Sprintf("updating deployment %s with state %s", deploymentId, update.State))
+    if update.ContainerIds == nil {
+        update.ContainerIds = []string{}
+    }
+    query := `UPDATE deployments SET state=$2,error=$3,
+        commit_sha=CASE WHEN $4 <> '' THEN $4 ELSE commit_sha END,
+        failed_step=$5,
+        duration_ms=CASE WHEN $6 > 0 THEN $6 ELSE duration_ms END,
+        container_ids=CASE WHEN cardinality($7::TEXT[]) > 0 THEN $7 ELSE container_ids END,
+        started_at=CASE WHEN $2 = 'building' THEN COALESCE(started_at, NOW()) ELSE started_at END,
+        finished_at=CASE WHEN $2 IN ('succeeded', 'failed', 'skipped') THEN NOW() ELSE finished_at END
+        WHERE deployment_id=$1 AND state NOT IN ('succeeded', 'failed', 'skipped')`
+    result, err := db.conn.Exec(context.Background(), query, deploymentId, update.State, update.Error, update.CommitSha, update.FailedStep, update.DurationMs, update.ContainerIds)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to update deployment %s: %v", deploymentId, err))
+        return err
+    }
+    if result.RowsAffected() == 0 {
+        log.Info(fmt.Sprintf("ignoring %s state of deployment %s that does not exist or has already been settled", update.State, deploymentId))
+    }
+    return nil
+}
+
+func (db Persistence) getDeployment(deploymentId uuid.UUID) (Deployment, error) {
+    log.Debug(fmt.Sprintf("retrieving deployment with ID %s", deploymentId))
+    results := db.conn.QueryRow(context.Background(), "SELECT " + deploymentColumns + " FROM deployments d WHERE d.deployment_id=$1", deploymentId)
+    deployment, err := scanDeployment(results)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve deployment %s: %v", deploymentId, err))
+        return Deployment{}, err
+    }
+    return deployment, nil
+}
+
+// function used to store lines of deployment build log. lines that
+// have already been stored are ignored
+func (db Persistence) createDeploymentLogs(deploymentId uuid.UUID, lines []events.BuildLogLine) error {
+    log.Debug(fmt.Sprintf("storing %d build log lines for deployment %s", len(lines), deploymentId))
+    batch := &pgx.Batch{}
+    for _, line := range(lines) {
+        batch.Queue("INSERT INTO deployment_logs(deployment_id,sequence,stream,line,logged_at) VALUES($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING", deploymentId, line.Sequence, line.Stream, line.Line, line.Timestamp)
+    }
+    results := db.conn.SendBatch(context.Background(), batch)
+    defer results.Close()
+    for range(lines) {
+        if _, err := results.Exec(); err != nil {
+            log.Error(fmt.Errorf("unable to insert values into deployment logs table: %v", err))
+            return err
+        }
+    }
+    return nil
+}
+
+// function used to retrieve lines of deployment build log after a
+// given sequence number. Note that a limit of 0 returns all lines
+func (db Persistence) getDeploymentLogs(deploymentId uuid.UUID, after int64, limit int) ([]DeploymentLogLine, error) {
+    query := "SELECT sequence,stream,line,logged_at FROM deployment_logs WHERE deployment_id=$1 AND sequence > $2 ORDER BY sequence"
+    if limit > 0 {
+        query += fmt.Sprintf(" LIMIT %d", limit)
+    }
+    values := []DeploymentLogLine{}
+    rows, err := db.conn.Query(context.Background(), query, deploymentId, after)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve deployment logs: %v", err))
+        return values, err
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var line DeploymentLogLine
+        if err := rows.Scan(&line.Sequence, &line.Stream, &line.Line, &line.Timestamp); err != nil {
+            log.Error(fmt.Errorf("unable to process row: %v", err))
+            return values, err
+        }
+        values = append(values, line)
+    }
+    return values, rows.Err()
+}
+
+// function used to retrieve the most recent deployment of an entry in a given state
+func (db Persistence) getLatestDeployment(entryId uuid.UUID, state string) (Deployment, error) {
+    log.Debug(fmt.Sprintf("retrieving latest deployment for entry %s with state %s", entryId, state))
+    results := db.conn.QueryRow(context.Background(), "SELECT " + deploymentColumns + " FROM deployments d WHERE d.entry_id=$1 AND d.state=$2 ORDER BY d.created_at DESC LIMIT 1", entryId, state)
+    deployment, err := scanDeployment(results)
+    if err != nil {
+        return Deployment{}, err
+    }
+    return deployment, nil
+}
+
+// function used to retrieve a page of deployments that belong to a given repo entry
+func (db Persistence) getEntryDeployments(entryId uuid.UUID, options ListOptions) ([]Deployment, string, error) {
+    log.Debug(fmt.Sprintf("retrieving deployments for entry %s with options %+v", entryId, options))
+    values := []Deployment{}
+    columns := listColumns{ Id: "d.deployment_id", Uid: "r.uid", RepoUrl: "r.repo_url", CreatedAt: "d.created_at" }
+    query, args, err := options.apply("SELECT " + deploymentColumns + ",r.repo_url FROM deployments d JOIN repo_entries r ON r.entry_id = d.entry_id WHERE d.entry_id = $1", columns, []interface{}{ entryId })
+    if err != nil {
+        return values, "", err
+    }
+    rows, err := db.conn.Query(context.Background(), query, args...)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve deployments: %v", err))
+        return values, "", err
+    }
+    defer rows.Close()
+
+    repoUrls := []string{}
+    for rows.Next() {
+        var repoUrl string
+        deployment, err := scanDeployment(rows, &repoUrl)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to process row: %v", err))
+        } else {
+            values = append(values, deployment)
+            repoUrls = append(repoUrls, repoUrl)
+        }
+    }
+
+    // trim additional row used to determine if there are more pages
+    if options.Limit > 0 && len(values) > options.Limit {
+        values = values[:options.Limit]
+        last := values[len(values) - 1]
+        return values, newCursor(options, last.DeploymentId, repoUrls[len(values) - 1], last.CreatedAt), nil
+    }
+    return values, "", nil
+}
+
+// function used to store restart of crashed container. restarts are
+// identified by the ID of the event that reported them, so redelivered
+// events are only stored once
+func (db Persistence) createContainerRestart(restart ContainerRestart) error {
+    log.Debug(fmt.Sprintf("storing restart of container %s for entry %s", restart.ContainerId, restart.EntryId))
+    _, err := db.conn.Exec(context.Background(), "INSERT INTO container_restarts(restart_id,entry_id,container_id,container_name,exit_code,attempt,initiator,error) VALUES($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT DO NOTHING", restart.RestartId, restart.EntryId, restart.ContainerId, restart.ContainerName, restart.ExitCode, restart.Attempt, restart.Initiator, restart.Error)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to insert values into container_restarts table: %v", err))
+        return err
+    }
+    return nil
+}
+
+// function used to retrieve a page of container restarts that belong to a given repo entry
+func (db Persistence) getEntryContainerRestarts(entryId uuid.UUID, options ListOptions) ([]ContainerRestart, string, error) {
+    log.Debug(fmt.Sprintf("retrieving container restarts for entry %s with options %+v", entryId, options))
+    values := []ContainerRestart{}
+    columns := listColumns{ Id: "c.restart_id", Uid: "r.uid", RepoUrl: "r.repo_url", CreatedAt: "c.created_at" }
+    query, args, err := options.apply("SELECT c.restart_id,c.entry_id,c.container_id,c.container_name,c.exit_code,c.attempt,c.initiator,c.error,c.created_at,r.repo_url FROM container_restarts c JOIN repo_entries r ON r.entry_id = c.entry_id WHERE c.entry_id = $1", columns, []interface{}{ entryId })
+    if err != nil {
+        return values, "", err
+    }
+    rows, err := db.conn.Query(context.Background(), query, args...)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve container restarts: %v", err))
+        return values, "", err
+    }
+    defer rows.Close()
+
+    repoUrls := []string{}
+    for rows.Next() {
+        var (restart ContainerRestart; repoUrl string)
+        err := rows.Scan(&restart.RestartId, &restart.EntryId, &restart.ContainerId, &restart.ContainerName, &restart.ExitCode, &restart.Attempt, &restart.Initiator, &restart.Error, &restart.CreatedAt, &repoUrl)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to process row: %v", err))
+        } else {
+            values = append(values, restart)
+            repoUrls = append(repoUrls, repoUrl)
+        }
+    }
+
+    // trim additional row used to determine if there are more pages
+    if options.Limit > 0 && len(values) > options.Limit {
+        values = values[:options.Limit]
+        last := values[len(values) - 1]
+        return values, newCursor(options, last.RestartId, repoUrls[len(values) - 1], last.CreatedAt), nil
+    }
+    return values, "", nil
+}
+
+// function used to store dead-lettered event. dead letters are identified
+// by their message ID, so redelivered dead letters are only stored once
+func (db Persistence) createDeadLetter(deadLetter DeadLetter) error {
+    log.Debug(fmt.Sprintf("storing dead letter %s from queue %s", deadLetter.DeadLetterId, deadLetter.Queue))
+    _, err := db.conn.Exec(context.Background(), "INSERT INTO dead_letters(dead_letter_id,event_id,event_type,entry_id,queue,exchange,routing_key,attempts,error,payload) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) ON CONFLICT DO NOTHING", deadLetter.DeadLetterId, deadLetter.EventId, deadLetter.EventType, deadLetter.EntryId, deadLetter.Queue, deadLetter.Exchange, deadLetter.RoutingKey, deadLetter.Attempts, deadLetter.Error, deadLetter.Payload)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to insert values into dead_letters table: %v", err))
+        return err
+    }
+    return nil
+}
+
+// function used to retrieve a page of dead letters. dead letters are
+// joined with the entries of their events so that they can be filtered
+// by user and repo URL. Note that payloads are not retrieved
+func (db Persistence) getDeadLetters(queue string, options ListOptions) ([]DeadLetter, string, error) {
+    log.Debug(fmt.Sprintf("retrieving dead letters of queue '%s' with options %+v", queue, options))
+    values := []DeadLetter{}
+    columns := listColumns{ Id: "d.dead_letter_id", Uid: "r.uid", RepoUrl: "COALESCE(r.repo_url, '')", CreatedAt: "d.created_at" }
+    query := "SELECT d.dead_letter_id,d.event_id,d.event_type,d.entry_id,d.queue,d.exchange,d.routing_key,d.attempts,d.error,d.created_at,COALESCE(r.repo_url, '') FROM dead_letters d LEFT JOIN repo_entries r ON r.entry_id = d.entry_id"
+    args := []interface{}{}
+    if len(queue) > 0 {
+        query += " WHERE d.queue = $1"
+        args = append(args, queue)
+    }
+    query, args, err := options.apply(query, columns, args)
+    if err != nil {
+        return values, "", err
+    }
+    rows, err := db.conn.Query(context.Background(), query, args...)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve dead letters: %v", err))
+        return values, "", err
+    }
+    defer rows.Close()
+
+    repoUrls := []string{}
+    for rows.Next() {
+        var (deadLetter DeadLetter; repoUrl string)
+        err := rows.Scan(&deadLetter.DeadLetterId, &deadLetter.EventId, &deadLetter.EventType, &deadLetter.EntryId, &deadLetter.Queue, &deadLetter.Exchange, &deadLetter.RoutingKey, &deadLetter.Attempts, &deadLetter.Error, &deadLetter.CreatedAt, &repoUrl)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to process row: %v", err))
+        } else {
+            values = append(values, deadLetter)
+            repoUrls = append(repoUrls, repoUrl)
+        }
+    }
+
+    // trim additional row used to determine if there are more pages
+    if options.Limit > 0 && len(values) > options.Limit {
+        values = values[:options.Limit]
+        last := values[len(values) - 1]
+        return values, newCursor(options, last.DeadLetterId, repoUrls[len(values) - 1], last.CreatedAt), nil
+    }
+    return values, "", nil
+}
+
+// function used to retrieve dead letter along with its payload
+func (db Persistence) getDeadLetter(deadLetterId uuid.UUID) (DeadLetter, error) {
+    log.Debug(fmt.Sprintf("retrieving dead letter with ID %s", deadLetterId))
+    var deadLetter DeadLetter
+    row := db.conn.QueryRow(context.Background(), "SELECT dead_letter_id,event_id,event_type,entry_id,queue,exchange,routing_key,attempts,error,payload,created_at FROM dead_letters WHERE dead_letter_id=$1", deadLetterId)
+    err := row.Scan(&deadLetter.DeadLetterId, &deadLetter.EventId, &deadLetter.EventType, &deadLetter.EntryId, &deadLetter.Queue, &deadLetter.Exchange, &deadLetter.RoutingKey, &deadLetter.Attempts, &deadLetter.Error, &deadLetter.Payload, &deadLetter.CreatedAt)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve dead letter %s: %v", deadLetterId, err))
+        return DeadLetter{}, err
+    }
+    return deadLetter, nil
+}
+
+// function used to delete dead letters. a single dead letter is deleted
+// if an ID is given, else all dead letters of the queue are deleted, or
+// all dead letters if no queue is given. the number of deleted rows is returned
+func (db Persistence) deleteDeadLetters(deadLetterId uuid.UUID, queue string) (int64, error) {
+    query, args := "DELETE FROM dead_letters", []interface{}{}
+    switch {
+    case deadLetterId != uuid.Nil:
+        query, args = query + " WHERE dead_letter_id=$1", append(args, deadLetterId)
+    case len(queue) > 0:
+        query, args = query + " WHERE queue=$1", append(args, queue)
+    }
+    log.Debug(fmt.Sprintf("deleting dead letters with query %s", query))
+    result, err := db.conn.Exec(context.Background(), query, args...)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to delete dead letters: %v", err))
+        return 0, err
+    }
+    return result.RowsAffected(), nil
+}
@@ -2,63 +2,291 @@ package api
 
 import (
     "fmt"
+    "time"
+    "context"
     "encoding/json"
     "github.com/PSauerborn/go-get-git/pkg/events"
     "github.com/google/uuid"
     "github.com/gin-gonic/gin"
     "github.com/google/go-github/github"
+    "github.com/PSauerborn/go-get-git/pkg/broker"
     log "github.com/sirupsen/logrus"
-    rabbit "github.com/PSauerborn/go-jackrabbit"
 )
 
 
-// function used to process git event by sending message over rabbitmq server
+// function used to process git event by sending message over rabbitmq server.
+// Pushes to the tracked branch are processed for entries that deploy on
+// branches, and pushes that move existing tags are processed for entries
+// that deploy on tags. Note that new tags are handled by create events
 func processGitPushEvent(ctx *gin.Context, e *github.PushEvent) {
-    log.Info(fmt.Sprintf("received master push event for repo %s. sending message to worker", *e.Repo.URL))
+    log.Info(fmt.Sprintf("received push event for repo %s", e.GetRepo().GetURL()))
     // get repo entry from database
-    entry, err := persistence.getRepoEntryByRepoUrl(*e.Repo.URL)
+    entry, err := persistence.getRepoEntryByRepoUrl(e.GetRepo().GetURL())
     if err != nil {
         log.Error(fmt.Errorf("unable to get repo entry: %v", err))
-    } else {
-        log.Info(fmt.Sprintf("retrieved Repo Entry %+v", entry))
-        // get file directory of application from database
-        dir, err := persistence.getEntryDirectory(entry.EntryId)
-        if err != nil {
-            log.Error(fmt.Errorf("unable to fetch application directory: %s", err))
-        } else {
-            // generate rabbitMQ event and send over rabbit server to daemon
-            payload := events.GitPushEvent{RepoUrl: *e.Repo.URL, ApplicationDirectory: dir}
-            event := events.New("GitPushEvent", ApplicationId, uuid.New(), payload)
-            sendRabbitPayload(event)
+        return
+    }
+    if e.GetDeleted() {
+        log.Info(fmt.Sprintf("received push event for deleted ref %s", e.GetRef()))
+        return
+    }
+
+    switch entry.DeployTrigger {
+    case DeployOnBranch:
+        if branch, ok := getPushEventBranch(e); ok && matchesRefPattern(entry.TrackedBranch, branch) {
+            triggerDeployment(entry, deploymentRequest{
+                Trigger: TriggerPush,
+                Ref: e.GetRef(),
+                CommitSha: e.GetAfter(),
+                CommitMessage: e.GetHeadCommit().GetMessage(),
+                TriggeredBy: e.GetPusher().GetName(),
+            })
+            return
+        }
+    case DeployOnTag:
+        if tag, ok := getPushEventTag(e); ok && !e.GetCreated() && matchesRefPattern(entry.TagPattern, tag) {
+            triggerDeployment(entry, deploymentRequest{
+                Trigger: TriggerTag,
+                Ref: e.GetRef(),
+                CommitSha: e.GetAfter(),
+                Tag: tag,
+                CommitMessage: e.GetHeadCommit().GetMessage(),
+                TriggeredBy: e.GetPusher().GetName(),
+            })
+            return
         }
     }
+    log.Info(fmt.Sprintf("received push event to untracked ref %s for entry with deploy trigger %s", e.GetRef(), entry.DeployTrigger))
+}
+
+// function used to process git create events. events are only processed
+// if a new tag is created for an entry that deploys on tags. Since create
+// events do not carry the tagged commit, the commit is resolved from the
+// git server so that the deployment is pinned to the tagged commit
+func processGitCreateEvent(ctx *gin.Context, e *github.CreateEvent) {
+    log.Info(fmt.Sprintf("received create event for repo %s", e.GetRepo().GetHTMLURL()))
+    if e.GetRefType() != "tag" {
+        log.Info(fmt.Sprintf("received create event for ref type %s", e.GetRefType()))
+        return
+    }
+    // note that repo URLs of non-push events point to the Github API
+    entry, err := persistence.getRepoEntryByRepoUrl(e.GetRepo().GetHTMLURL())
+    if err != nil {
+        log.Error(fmt.Errorf("unable to get repo entry: %v", err))
+        return
+    }
+    if entry.DeployTrigger != DeployOnTag || !matchesRefPattern(entry.TagPattern, e.GetRef()) {
+        log.Info(fmt.Sprintf("received untracked tag %s for entry with deploy trigger %s", e.GetRef(), entry.DeployTrigger))
+        return
+    }
+    sha, err := getGitCommitSha(entry.RepoOwner, entry.RepoName, entry.AccessToken, "refs/tags/" + e.GetRef())
+    if err != nil {
+        log.Error(fmt.Errorf("unable to resolve commit of tag %s: %v", e.GetRef(), err))
+        return
+    }
+    triggerDeployment(entry, deploymentRequest{
+        Trigger: TriggerTag,
+        Ref: "refs/tags/" + e.GetRef(),
+        CommitSha: sha,
+        Tag: e.GetRef(),
+        TriggeredBy: e.GetSender().GetLogin(),
+    })
+}
+
+// function used to process git release events. events are only processed
+// if a release is published for an entry that deploys on releases
+func processGitReleaseEvent(ctx *gin.Context, e *github.ReleaseEvent) {
+    log.Info(fmt.Sprintf("received release event for repo %s", e.GetRepo().GetHTMLURL()))
+    if e.GetAction() != "published" {
+        log.Info(fmt.Sprintf("received release event with action %s", e.GetAction()))
+        return
+    }
+    // note that repo URLs of non-push events point to the Github API
+    entry, err := persistence.getRepoEntryByRepoUrl(e.GetRepo().GetHTMLURL())
+    if err != nil {
+        log.Error(fmt.Errorf("unable to get repo entry: %v", err))
+        return
+    }
+    tag := e.GetRelease().GetTagName()
+    if entry.DeployTrigger != DeployOnRelease || !matchesRefPattern(entry.TagPattern, tag) {
+        log.Info(fmt.Sprintf("received untracked release %s for entry with deploy trigger %s", tag, entry.DeployTrigger))
+        return
+    }
+    triggerDeployment(entry, deploymentRequest{
+        Trigger: TriggerRelease,
+        Ref: "refs/tags/" + tag,
+        Tag: tag,
+        CommitMessage: e.GetRelease().GetName(),
+        TriggeredBy: e.GetSender().GetLogin(),
+    })
+}
+
+// function used to generate the directory that an application is deployed in
+func getApplicationDirectory(application string) string {
+    return BaseApplicationDirectory + application
 }
 
-func processNewApplicationEvent(ctx *gin.Context, entryId uuid.UUID, user, application, url string) error {
-    err := persistence.createEntryDirectory(entryId, BaseApplicationDirectory + application)
+// function used to notify daemon that an application has been removed
+func processRemoveApplicationEvent(ctx *gin.Context, entry GitRepoEntry, directory string) error {
+    // generate rabbitMQ event and send over rabbit server to daemon
+    payload := events.RemoveGitRepoEvent{EntryId: entry.EntryId, RepoUrl: entry.RepoUrl, ApplicationDirectory: directory, Builder: entry.Builder}
+    event := events.New("RemoveGitRepoEvent", ApplicationId, uuid.New(), payload)
+    return sendDaemonEvent(entry.TargetNode, event)
+}
+
+// function used to move application to new directory and notify
+// daemon that the application checkout should be moved
+func processMoveApplicationEvent(ctx *gin.Context, entry GitRepoEntry) error {
+    previous, err := persistence.getEntryDirectory(entry.EntryId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to create new application directory entry: %v", err))
+        log.Error(fmt.Errorf("unable to fetch application directory: %v", err))
         return err
-    } else {
-        // generate rabbitMQ event and send over rabbit server to daemon
-        payload := events.NewGitRepoEvent{RepoUrl: url, ApplicationDirectory: BaseApplicationDirectory + application}
-        event := events.New("NewGitRepoEvent", ApplicationId, uuid.New(), payload)
-        sendRabbitPayload(event)
+    }
+    directory := getApplicationDirectory(entry.RepoName)
+    if previous == directory {
         return nil
     }
+    if err := persistence.updateEntryDirectory(entry.EntryId, directory); err != nil {
+        log.Error(fmt.Errorf("unable to update application directory entry: %v", err))
+        return err
+    }
+    // generate rabbitMQ event and send over rabbit server to daemon
+    payload := events.MoveGitRepoEvent{
+        EntryId: entry.EntryId,
+        RepoUrl: entry.RepoUrl,
+        PreviousApplicationDirectory: previous,
+        ApplicationDirectory: directory,
+        Builder: entry.Builder,
+    }
+    event := events.New("MoveGitRepoEvent", ApplicationId, uuid.New(), payload)
+    return sendDaemonEvent(entry.TargetNode, event)
 }
 
-// define function used to send message over rabbitmq server
-func sendRabbitPayload(event events.Event) error {
+// function used to move application to a new target node. the application
+// is torn down on the previous node and checked out on the new node, after
+// which the latest revision is redeployed on the new node. Note that the
+// application directory is updated if the repo has been renamed
+func processRelocateApplicationEvent(ctx *gin.Context, previous, entry GitRepoEntry) error {
+    previousDirectory, err := persistence.getEntryDirectory(entry.EntryId)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to fetch application directory: %v", err))
+        return err
+    }
+    directory := getApplicationDirectory(entry.RepoName)
+    if previousDirectory != directory {
+        if err := persistence.updateEntryDirectory(entry.EntryId, directory); err != nil {
+            log.Error(fmt.Errorf("unable to update application directory entry: %v", err))
+            return err
+        }
+    }
+    log.Info(fmt.Sprintf("moving entry %s from node '%s' to node '%s'", entry.EntryId, previous.TargetNode, entry.TargetNode))
+    if err := processRemoveApplicationEvent(ctx, previous, previousDirectory); err != nil {
+        return err
+    }
+    payload := events.NewGitRepoEvent{EntryId: entry.EntryId, RepoUrl: entry.RepoUrl, ApplicationDirectory: directory}
+    if err := sendDaemonEvent(entry.TargetNode, events.New("NewGitRepoEvent", ApplicationId, uuid.New(), payload)); err != nil {
+        return err
+    }
+    ref, sha, err := getRedeployTarget(entry)
+    if err != nil {
+        return err
+    }
+    if len(ref) == 0 && len(sha) == 0 {
+        log.Info(fmt.Sprintf("no revision to redeploy entry %s with on node '%s'", entry.EntryId, entry.TargetNode))
+        return nil
+    }
+    _, err = triggerDeployment(entry, deploymentRequest{ Trigger: TriggerManual, Ref: ref, CommitSha: sha, TriggeredBy: getUser(ctx) })
+    return err
+}
 
-    RabbitConfig := rabbit.RabbitConnectionConfig{
-        QueueURL: RabbitQueueUrl,
-        ExchangeName: "events",
-        ExchangeType: "fanout",
+// function used to listen for events sent by daemons. events that
+// cannot be processed are retried and eventually dead-lettered. Note that
+// the function blocks while listening and should be run in a goroutine
+func ListenForEvents() {
+    consumer := consumerConfig(RabbitQueueName, broker.ApiRoutingKey)
+    err := broker.Listen(context.Background(), exchangeConfig(), consumer, broker.Handle(processRabbitMessage))
+    if err != nil {
+        log.Fatal(fmt.Errorf("unable to create rabbitmq listener: %v", err))
     }
-    // construct payload and send over rabbit server
+}
+
+// function used to process events sent by the daemon. build events
+// are used to update the state of deployments, and container restarts
+// are recorded for the entries that the containers belong to. events
+// that cannot be parsed are dead-lettered without being retried
+func processRabbitMessage(payload []byte) error {
+    event, err := events.ParseEvent(payload)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to parse event: %s", err))
+        return broker.Permanent(err)
+    }
+    switch e := event.EventPayload.(type) {
+    case events.BuildTriggeredEvent:
+        err = processDeploymentState(e.DeploymentId, deploymentUpdate{ State: DeploymentBuilding, CommitSha: e.CommitSha })
+    case events.BuildFailedEvent:
+        err = processDeploymentState(e.DeploymentId, deploymentUpdate{
+            State: DeploymentFailed,
+            Error: e.Error,
+            CommitSha: e.CommitSha,
+            FailedStep: e.FailedStep,
+            DurationMs: e.DurationMs,
+        })
+    case events.BuildCompletedEvent:
+        err = processBuildCompletedEvent(e)
+    case events.BuildLogEvent:
+        err = persistence.createDeploymentLogs(e.DeploymentId, e.Lines)
+    case events.ContainerCrashedEvent:
+        log.Warn(fmt.Sprintf("container %s of entry %s crashed with exit code %d (restarts exhausted: %t)", e.ContainerName, e.EntryId, e.ExitCode, e.RestartsExhausted))
+    case events.ContainerRestartEvent:
+        err = persistence.createContainerRestart(ContainerRestart{
+            RestartId: event.EventId,
+            EntryId: e.EntryId,
+            ContainerId: e.ContainerId,
+            ContainerName: e.ContainerName,
+            ExitCode: e.ExitCode,
+            Attempt: e.Attempt,
+            Initiator: e.Initiator,
+            Error: e.Error,
+        })
+    default:
+        log.Debug(fmt.Sprintf("ignoring event type %s", event.EventType))
+    }
+    if err != nil {
+        log.Error(fmt.Errorf("unable to process %s: %v", event.EventType, err))
+    }
+    return err
+}
+
+// function used to send event to daemons. events are routed to the
+// daemon running on the given node, or to all daemons if no node is given
+func sendDaemonEvent(node string, event events.Event) error {
     body, _ := json.Marshal(&event)
-    return rabbit.ConnectAndDeliverOverExchange(RabbitConfig, body)
+    return broker.Publish(exchangeConfig(), broker.DaemonRoutingKey(node), event.EventId.String(), body)
 }
 
+// helper function used to generate config of consumer listening on queue
+func consumerConfig(queue string, routingKeys ...string) broker.ConsumerConfig {
+    return broker.ConsumerConfig{
+        Queue: queue,
+        RoutingKeys: routingKeys,
+        MaxAttempts: MaxDeliveryAttempts,
+        RetryBackoff: time.Duration(RetryBackoffSeconds) * time.Second,
+        MaxRetryBackoff: time.Duration(MaxRetryBackoffSeconds) * time.Second,
+        DeadLetterQueue: DeadLetterQueueName,
+    }
+}
 
+// helper function used to generate config of event exchange
+func exchangeConfig() broker.ExchangeConfig {
+    return broker.ExchangeConfig{
+        Url: RabbitQueueUrl,
+        ExchangeName: EventExchangeName,
+        ExchangeType: EventExchangeType,
+    }
+}
+
+// function used to check if target node is valid. entries without a
+// target node are deployed by all daemons
+func isValidTargetNode(node string) bool {
+    return len(node) == 0 || broker.ValidateNode(node) == nil
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+    "fmt"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// define states that registrations can be in
+const (
+    RegistrationPending = "pending"
+    RegistrationCompleted = "completed"
+    RegistrationRolledBack = "rolled_back"
+    RegistrationFailed = "failed"
+)
+
+// function used to register new repo. the git hook and deploy key are
+// created on the git server before any database entries are written and
+// are compensated by removing them from the git server if any of the
+// subsequent steps fail. database entries are then created in a single
+// transaction, so that no connections or locks are held while waiting
+// on the git server. the new application event is only published once the
+// transaction has been committed, so that the daemon can retrieve the
+// entry when processing the event. The final state of the registration
+// is returned along with any errors
+func registerRepoEntry(user string, body NewRegistryEntry) (Registration, error) {
+    registration := Registration{
+        RegistrationId: uuid.New(),
+        Uid: user,
+        RepoUrl: body.RepoUrl,
+        State: RegistrationPending,
+        Step: "created",
+    }
+    if err := persistence.createRegistration(registration); err != nil {
+        return registration, err
+    }
+    s := saga{ registration: &registration }
+    directory := getApplicationDirectory(body.RepoName)
+
+    var (entryId uuid.UUID; meta GitHookMeta; key DeployKey)
+    // create new git hook on git server
+    err := s.run("create_git_hook", func() error {
+        hook, err := createGitWebHook(body.RepoOwner, body.RepoName, body.RepoAccessToken)
+        meta = hook
+        return err
+    }, func() error {
+        return deleteGitWebHook(body.RepoOwner, body.RepoName, body.RepoAccessToken, meta.GitHookId)
+    })
+
+    // generate read-only deploy key and register key on git server
+    if err == nil && body.CredentialType == CredentialDeployKey {
+        err = s.run("create_deploy_key", func() error {
+            created, err := createEntryDeployKey(body.RepoOwner, body.RepoName, body.RepoAccessToken)
+            key = created
+            return err
+        }, func() error {
+            return deleteGitDeployKey(body.RepoOwner, body.RepoName, body.RepoAccessToken, key.GitKeyId)
+        })
+    }
+
+    if err == nil {
+        err = s.run("create_repo_entry", func() error {
+            return persistence.withTransaction(func(tx Persistence) error {
+                id, err := tx.createRepoEntry(user, body)
+                if err != nil {
+                    return err
+                }
+                entryId = id
+                if err := tx.createEntryDirectory(entryId, directory); err != nil {
+                    return err
+                }
+                if _, err := tx.createHookEntry(entryId, meta); err != nil {
+                    return err
+                }
+                if body.CredentialType == CredentialDeployKey {
+                    return tx.createDeployKey(entryId, key)
+                }
+                return nil
+            })
+        }, func() error {
+            return persistence.removeRepoEntry(entryId)
+        })
+    }
+
+    // notify daemon of new application once the entries have been committed
+    if err == nil {
+        err = s.run("publish_new_application_event", func() error {
+            payload := events.NewGitRepoEvent{EntryId: entryId, RepoUrl: body.RepoUrl, ApplicationDirectory: directory}
+            return sendDaemonEvent(body.TargetNode, events.New("NewGitRepoEvent", ApplicationId, registration.RegistrationId, payload))
+        }, nil)
+    }
+
+    if err != nil {
+        log.Error(fmt.Errorf("unable to register repo %s: %v. rolling back registration", body.RepoUrl, err))
+        registration.Error = err.Error()
+        registration.State = RegistrationRolledBack
+        if !s.compensate() {
+            registration.State = RegistrationFailed
+        }
+    } else {
+        registration.EntryId = &entryId
+        registration.State = RegistrationCompleted
+    }
+    if updateErr := persistence.updateRegistration(registration); updateErr != nil {
+        log.Warn(fmt.Sprintf("unable to update registration %s: %v", registration.RegistrationId, updateErr))
+    }
+    return registration, err
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+    "fmt"
+    log "github.com/sirupsen/logrus"
+)
+
+// struct used to run a set of steps as a saga. each step can register a
+// compensation that is executed if any of the subsequent steps fail. the
+// registration is optional and tracks the current step of registrations
+type saga struct {
+    registration  *Registration
+    compensations []func() error
+}
+
+// function used to execute saga step. the current step is stored on
+// the registration so that failing steps can be reported to the user
+func (s *saga) run(step string, action func() error, compensation func() error) error {
+    if s.registration != nil {
+        s.registration.Step = step
+        if err := persistence.updateRegistration(*s.registration); err != nil {
+            log.Warn(fmt.Sprintf("unable to update registration %s: %v", s.registration.RegistrationId, err))
+        }
+    }
+    if err := action(); err != nil {
+        if s.registration == nil {
+            return fmt.Errorf("step %s failed: %v", step, err)
+        }
+        return fmt.Errorf("registration step %s failed: %v", step, err)
+    }
+    if compensation != nil {
+        s.compensations = append(s.compensations, compensation)
+    }
+    return nil
+}
+
+// function used to execute compensations in reverse order. compensations
+// are always executed even if previous compensations have failed
+func (s *saga) compensate() bool {
+    ok := true
+    for i := len(s.compensations) - 1; i >= 0; i-- {
+        if err := s.compensations[i](); err != nil {
+            if s.registration != nil {
+                log.Error(fmt.Errorf("unable to compensate registration %s: %v", s.registration.RegistrationId, err))
+            } else {
+                log.Error(fmt.Errorf("unable to compensate saga step: %v", err))
+            }
+            ok = false
+        }
+    }
+    return ok
+}
@@ -0,0 +1,223 @@
+package broker
+
+import (
+    "fmt"
+    "errors"
+    "time"
+    "context"
+    "strings"
+    "github.com/google/uuid"
+    "github.com/streadway/amqp"
+    log "github.com/sirupsen/logrus"
+)
+
+// define routing keys used to route events between the API and daemons.
+// events for daemons are routed to the node that an application is
+// deployed on, or to all daemons if the application has no target node
+const (
+    ApiRoutingKey = "api"
+    DaemonRoutingPrefix = "daemon."
+    BroadcastNode = "all"
+)
+
+var (
+    ConnectionClosedError = errors.New("rabbitmq connection closed")
+    InvalidNodeError = errors.New("invalid node ID")
+    // define backoff used to reconnect consumers that lost their connection
+    ReconnectBackoff = time.Second
+    MaxReconnectBackoff = time.Minute
+)
+
+// struct used to configure queue that a consumer listens on. failed
+// messages are retried with exponential backoff until the maximum number
+// of attempts is reached, after which they are moved to the dead-letter
+// queue. Note that messages are retried indefinitely if the maximum is 0,
+// and dropped once exhausted if no dead-letter queue is set
+type ConsumerConfig struct {
+    Queue           string
+    RoutingKeys     []string
+    Prefetch        int
+    MaxAttempts     int
+    RetryBackoff    time.Duration
+    MaxRetryBackoff time.Duration
+    DeadLetterQueue string
+}
+
+// struct used to configure exchange that events are published over.
+// exchanges must be direct or topic exchanges so that events can be routed
+type ExchangeConfig struct {
+    Url          string
+    ExchangeName string
+    ExchangeType string
+}
+
+// function used to generate routing key of events sent to daemons on a
+// given node. events are broadcast to all daemons if no node is given
+func DaemonRoutingKey(node string) string {
+    if len(node) == 0 {
+        node = BroadcastNode
+    }
+    return DaemonRoutingPrefix + node
+}
+
+// function used to validate node ID. node IDs are used as routing keys,
+// so wildcards and the broadcast node ID are not allowed
+func ValidateNode(node string) error {
+    if len(node) == 0 || node == BroadcastNode || strings.ContainsAny(node, "*# ") {
+        return InvalidNodeError
+    }
+    return nil
+}
+
+// function used to publish message over exchange with routing key. the
+// message ID should be the ID of the event so that it can be tracked
+// across retries and dead-lettering
+func Publish(config ExchangeConfig, routingKey, messageId string, body []byte) error {
+    conn, channel, err := connect(config)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    log.Debug(fmt.Sprintf("publishing message %s over exchange %s with routing key %s", messageId, config.ExchangeName, routingKey))
+    return channel.Publish(config.ExchangeName, routingKey, false, false, amqp.Publishing{
+        ContentType: "application/json",
+        DeliveryMode: amqp.Persistent,
+        MessageId: messageId,
+        Body: body,
+    })
+}
+
+// function used to publish message directly to a queue over the default
+// exchange. Note that the message is only delivered to the given queue
+func PublishToQueue(config ExchangeConfig, queue, messageId string, body []byte) error {
+    conn, channel, err := connect(config)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    log.Debug(fmt.Sprintf("publishing message %s to queue %s", messageId, queue))
+    return channel.Publish("", queue, false, false, amqp.Publishing{
+        ContentType: "application/json",
+        DeliveryMode: amqp.Persistent,
+        MessageId: messageId,
+        Body: body,
+    })
+}
+
+// function used to wrap handler that processes messages synchronously.
+// messages are acknowledged if the handler succeeds and retried otherwise
+func Handle(handler func(payload []byte) error) func(delivery *Delivery) {
+    return func(delivery *Delivery) {
+        if err := handler(delivery.Body); err != nil {
+            delivery.Retry(err)
+            return
+        }
+        delivery.Ack()
+    }
+}
+
+// function used to listen on queue bound to exchange with given routing
+// keys. handlers must settle each delivery exactly once, either directly
+// or once the message has been processed asynchronously. consumers that
+// fail or lose their connection are reconnected with exponential backoff.
+// Note that the function blocks until the context is cancelled, in which
+// case the consumer is cancelled and the function returns once all
+// received messages have been handled and settled
+func Listen(ctx context.Context, config ExchangeConfig, consumerConfig ConsumerConfig, handler func(delivery *Delivery)) error {
+    delay := ReconnectBackoff
+    for {
+        err := consume(ctx, config, consumerConfig, handler)
+        if err == nil || ctx.Err() != nil {
+            return err
+        }
+        // reset backoff if consumer was connected before losing its connection
+        if err == ConnectionClosedError {
+            delay = ReconnectBackoff
+        }
+        log.Error(fmt.Errorf("consumer on queue %s stopped: %v. reconnecting in %s", consumerConfig.Queue, err, delay))
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-time.After(delay):
+        }
+        if delay *= 2; delay > MaxReconnectBackoff {
+            delay = MaxReconnectBackoff
+        }
+    }
+}
+
+// helper function used to consume from queue on a single connection. the
+// function returns once the context is cancelled and all messages have
+// been settled, or once the connection is closed
+func consume(ctx context.Context, config ExchangeConfig, consumerConfig ConsumerConfig, handler func(delivery *Delivery)) error {
+    conn, channel, err := connect(config)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    queue := consumerConfig.Queue
+    if _, err := channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+        return fmt.Errorf("unable to declare queue %s: %v", queue, err)
+    }
+    for _, key := range(consumerConfig.RoutingKeys) {
+        log.Info(fmt.Sprintf("binding queue %s to exchange %s with routing key %s", queue, config.ExchangeName, key))
+        if err := channel.QueueBind(queue, key, config.ExchangeName, false, nil); err != nil {
+            return fmt.Errorf("unable to bind queue %s with routing key %s: %v", queue, key, err)
+        }
+    }
+    if len(consumerConfig.DeadLetterQueue) > 0 {
+        if _, err := channel.QueueDeclare(consumerConfig.DeadLetterQueue, true, false, false, false, nil); err != nil {
+            return fmt.Errorf("unable to declare dead-letter queue %s: %v", consumerConfig.DeadLetterQueue, err)
+        }
+    }
+    if consumerConfig.Prefetch > 0 {
+        if err := channel.Qos(consumerConfig.Prefetch, 0, false); err != nil {
+            return fmt.Errorf("unable to set prefetch count of queue %s: %v", queue, err)
+        }
+    }
+    c := &consumer{ config: consumerConfig, channel: channel, delayQueues: map[time.Duration]string{} }
+    tag := fmt.Sprintf("%s-%s", queue, uuid.New())
+    deliveries, err := channel.Consume(queue, tag, false, false, false, false, nil)
+    if err != nil {
+        return fmt.Errorf("unable to consume from queue %s: %v", queue, err)
+    }
+    for {
+        select {
+        case <-ctx.Done():
+            log.Info(fmt.Sprintf("stopping consumer on queue %s", queue))
+            if err := channel.Cancel(tag, false); err != nil {
+                return fmt.Errorf("unable to cancel consumer on queue %s: %v", queue, err)
+            }
+            // deliveries are closed once the server has confirmed the cancellation
+            for delivery := range(deliveries) {
+                handler(c.newDelivery(delivery))
+            }
+            log.Info(fmt.Sprintf("waiting for unsettled messages of queue %s", queue))
+            c.wg.Wait()
+            return nil
+        case delivery, ok := <-deliveries:
+            if !ok {
+                return ConnectionClosedError
+            }
+            handler(c.newDelivery(delivery))
+        }
+    }
+}
+
+// helper function used to connect to rabbitmq server and declare exchange
+func connect(config ExchangeConfig) (*amqp.Connection, *amqp.Channel, error) {
+    conn, err := amqp.Dial(config.Url)
+    if err != nil {
+        return nil, nil, fmt.Errorf("unable to connect to rabbitmq server: %v", err)
+    }
+    channel, err := conn.Channel()
+    if err != nil {
+        conn.Close()
+        return nil, nil, fmt.Errorf("unable to open rabbitmq channel: %v", err)
+    }
+    if err := channel.ExchangeDeclare(config.ExchangeName, config.ExchangeType, true, false, false, false, nil); err != nil {
+        conn.Close()
+        return nil, nil, fmt.Errorf("unable to declare exchange %s: %v", config.ExchangeName, err)
+    }
+    return conn, channel, nil
+}
@@ -0,0 +1,223 @@
+package broker
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "github.com/google/uuid"
+    "github.com/streadway/amqp"
+    log "github.com/sirupsen/logrus"
+)
+
+// define headers used to track origin and attempts of retried messages
+const (
+    HeaderAttempts = "x-go-get-git-attempts"
+    HeaderError = "x-go-get-git-error"
+    HeaderExchange = "x-go-get-git-exchange"
+    HeaderRoutingKey = "x-go-get-git-routing-key"
+    HeaderQueue = "x-go-get-git-queue"
+)
+
+// error used to mark failures that cannot be fixed by retrying. messages
+// that fail with permanent errors are dead-lettered without being retried
+type PermanentError struct {
+    Err error
+}
+
+func (e *PermanentError) Error() string {
+    return e.Err.Error()
+}
+
+// function used to mark error as permanent
+func Permanent(err error) error {
+    return &PermanentError{ Err: err }
+}
+
+// struct used to store message delivered to a consumer. the exchange,
+// routing key and queue are those that the message was originally
+// delivered with, even if the message has been retried since
+type Delivery struct {
+    MessageId  string
+    Body       []byte
+    Exchange   string
+    RoutingKey string
+    Queue      string
+    Attempt    int
+    Error      string
+    delivery   amqp.Delivery
+    consumer   *consumer
+    once       sync.Once
+}
+
+// function used to acknowledge that message has been processed
+func (d *Delivery) Ack() {
+    d.settle(func() error {
+        return d.delivery.Ack(false)
+    })
+}
+
+// function used to return message to its queue without counting the
+// attempt, which is used for messages that were not processed at all
+func (d *Delivery) Requeue() {
+    d.settle(func() error {
+        return d.delivery.Nack(false, true)
+    })
+}
+
+// function used to retry message after it failed with the given error.
+// messages are republished to a delay queue that returns them to the
+// queue once the backoff has expired, and are dead-lettered once the
+// maximum number of attempts is reached or the error is permanent
+func (d *Delivery) Retry(cause error) {
+    d.settle(func() error {
+        return d.consumer.retry(d, cause)
+    })
+}
+
+// helper function used to settle delivery exactly once
+func (d *Delivery) settle(settle func() error) {
+    d.once.Do(func() {
+        defer d.consumer.wg.Done()
+        if err := settle(); err != nil {
+            log.Error(fmt.Errorf("unable to settle message %s on queue %s: %v", d.MessageId, d.Queue, err))
+        }
+    })
+}
+
+// struct used to store state of consumer. deliveries are tracked until
+// they are settled so that consumers can be stopped without losing messages
+type consumer struct {
+    config      ConsumerConfig
+    channel     *amqp.Channel
+    mutex       sync.Mutex
+    delayQueues map[time.Duration]string
+    wg          sync.WaitGroup
+}
+
+// helper function used to create delivery from received message. the
+// origin of retried messages is restored from the message headers
+func (c *consumer) newDelivery(message amqp.Delivery) *Delivery {
+    c.wg.Add(1)
+    delivery := &Delivery{
+        MessageId: message.MessageId,
+        Body: message.Body,
+        Exchange: message.Exchange,
+        RoutingKey: message.RoutingKey,
+        Queue: c.config.Queue,
+        Attempt: 1,
+        delivery: message,
+        consumer: c,
+    }
+    if value, ok := message.Headers[HeaderExchange].(string); ok {
+        delivery.Exchange = value
+    }
+    if value, ok := message.Headers[HeaderRoutingKey].(string); ok {
+        delivery.RoutingKey = value
+    }
+    if value, ok := message.Headers[HeaderQueue].(string); ok {
+        delivery.Queue = value
+    }
+    if value, ok := message.Headers[HeaderError].(string); ok {
+        delivery.Error = value
+    }
+    switch value := message.Headers[HeaderAttempts].(type) {
+    case int32:
+        delivery.Attempt = int(value) + 1
+    case int64:
+        delivery.Attempt = int(value) + 1
+    case int:
+        delivery.Attempt = value + 1
+    }
+    return delivery
+}
+
+// helper function used to republish failed message to delay queue or
+// dead-letter queue. the original message is only acknowledged once it
+// has been republished, and is requeued if republishing fails
+func (c *consumer) retry(d *Delivery, cause error) error {
+    headers := amqp.Table{
+        HeaderAttempts: int32(d.Attempt),
+        HeaderError: cause.Error(),
+        HeaderExchange: d.Exchange,
+        HeaderRoutingKey: d.RoutingKey,
+        HeaderQueue: d.Queue,
+    }
+    messageId := d.MessageId
+    _, permanent := cause.(*PermanentError)
+    exhausted := c.config.MaxAttempts > 0 && d.Attempt >= c.config.MaxAttempts
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    var key string
+    if permanent || exhausted {
+        if len(c.config.DeadLetterQueue) == 0 {
+            log.Error(fmt.Errorf("dropping message %s on queue %s after %d attempt(s): %v", d.MessageId, d.Queue, d.Attempt, cause))
+            return d.delivery.Reject(false)
+        }
+        log.Error(fmt.Errorf("moving message %s on queue %s to dead-letter queue after %d attempt(s): %v", d.MessageId, d.Queue, d.Attempt, cause))
+        // dead letters keep the ID of the message so that they are only
+        // stored once. messages without IDs are given new IDs
+        key = c.config.DeadLetterQueue
+        if len(messageId) == 0 {
+            messageId = uuid.New().String()
+        }
+    } else {
+        delay := c.backoff(d.Attempt)
+        queue, err := c.delayQueue(delay)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to declare delay queue: %v", err))
+            return d.delivery.Nack(false, true)
+        }
+        log.Warn(fmt.Sprintf("retrying message %s on queue %s in %s after attempt %d: %v", d.MessageId, d.Queue, delay, d.Attempt, cause))
+        key = queue
+    }
+    err := c.channel.Publish("", key, false, false, amqp.Publishing{
+        ContentType: "application/json",
+        DeliveryMode: amqp.Persistent,
+        MessageId: messageId,
+        Headers: headers,
+        Body: d.Body,
+    })
+    if err != nil {
+        log.Error(fmt.Errorf("unable to republish message %s to queue %s: %v", d.MessageId, key, err))
+        return d.delivery.Nack(false, true)
+    }
+    return d.delivery.Ack(false)
+}
+
+// helper function used to declare delay queue for a given backoff.
+// messages expire from delay queues after the backoff and are returned
+// to the queue of the consumer over the default exchange. the mutex of
+// the consumer must be held when called
+func (c *consumer) delayQueue(delay time.Duration) (string, error) {
+    if queue, ok := c.delayQueues[delay]; ok {
+        return queue, nil
+    }
+    queue := fmt.Sprintf("%s.retry.%d", c.config.Queue, delay.Milliseconds())
+    _, err := c.channel.QueueDeclare(queue, true, false, false, false, amqp.Table{
+        "x-message-ttl": int32(delay.Milliseconds()),
+        "x-dead-letter-exchange": "",
+        "x-dead-letter-routing-key": c.config.Queue,
+    })
+    if err != nil {
+        return "", err
+    }
+    c.delayQueues[delay] = queue
+    return queue, nil
+}
+
+// helper function used to determine backoff before retry attempt. the
+// backoff is doubled with each attempt up to the maximum backoff
+func (c *consumer) backoff(attempt int) time.Duration {
+    delay, limit := c.config.RetryBackoff, c.config.MaxRetryBackoff
+    if delay <= 0 {
+        delay = time.Second
+    }
+    for i := 1; i < attempt && (limit <= 0 || delay < limit); i++ {
+        delay *= 2
+    }
+    if limit > 0 && delay > limit {
+        return limit
+    }
+    return delay
+}
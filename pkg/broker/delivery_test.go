@@ -0,0 +1,55 @@
+package broker
+
+import (
+    "time"
+    "testing"
+    "github.com/streadway/amqp"
+)
+
+func TestConsumerBackoff(t *testing.T) {
+    tests := []struct {
+        name    string
+        backoff time.Duration
+        limit   time.Duration
+        attempt int
+        delay   time.Duration
+    }{
+        { "backoff stays limited", 10 * time.Second, time.Minute, 50, time.Minute },
+        { "default backoff", 0, time.Minute, 2, 2 * time.Second },
+        { "unlimited backoff", time.Second, 0, 5, 16 * time.Second },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            c := &consumer{ config: ConsumerConfig{ RetryBackoff: test.backoff, MaxRetryBackoff: test.limit } }
+            if delay := c.backoff(test.attempt); delay != test.delay {
+                t.Errorf("expected delay %s, got %s", test.delay, delay)
+            }
+        })
+    }
+}
+
+func TestConsumerNewDelivery(t *testing.T) {
+    tests := []struct {
+        name       string
+        headers    amqp.Table
+        routingKey string
+        queue      string
+        attempt    int
+    }{
+        {
+            "retried delivery",
+            amqp.Table{ HeaderAttempts: int32(2), HeaderRoutingKey: "daemon.node-1", HeaderQueue: "daemon-events", HeaderError: "failed" },
+            "daemon.node-1", "daemon-events", 3,
+        },
+        { "attempts as int64", amqp.Table{ HeaderAttempts: int64(4) }, "api", "events", 5 },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            c := &consumer{ config: ConsumerConfig{ Queue: "events" } }
+            delivery := c.newDelivery(amqp.Delivery{ MessageId: "message", RoutingKey: "api", Headers: test.headers })
+            if delivery.RoutingKey != test.routingKey || delivery.Queue != test.queue || delivery.Attempt != test.attempt || delivery.MessageId != "message" {
+                t.Errorf("expected routing key %s, queue %s and attempt %d, got %+v", test.routingKey, test.queue, test.attempt, delivery)
+            }
+        })
+    }
+}
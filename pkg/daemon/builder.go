@@ -0,0 +1,482 @@
+package daemon
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "bufio"
+    "errors"
+    "context"
+    "regexp"
+    "strings"
+    "io/ioutil"
+    "path/filepath"
+    "gopkg.in/yaml.v2"
+    log "github.com/sirupsen/logrus"
+)
+
+// define names of builders that applications can be deployed with
+const (
+    BuilderAuto = "auto"
+    BuilderCompose = "compose"
+    BuilderDockerfile = "dockerfile"
+    BuilderMakefile = "makefile"
+    BuilderScript = "script"
+)
+
+var (
+    UnknownBuilderError = errors.New("unknown builder")
+    NoBuilderDetectedError = errors.New("unable to detect builder from repo contents")
+    // define targets and scripts used by makefile and script builders
+    MakeDeployTarget = "deploy"
+    MakeTeardownTarget = "teardown"
+    DeployScript = "deploy.sh"
+    TeardownScript = "teardown.sh"
+)
+
+// struct used to store options that applications are built with. the
+// manifest is nil if the repo does not contain a deployment manifest,
+// and env contains the variables loaded from the env files of the manifest.
+// applications are built from the directory of a release, while project
+// and container names are derived from the application directory so that
+// they remain stable across releases. images are not rebuilt if NoBuild
+// is set, which is used to roll back to previous releases. labels are
+// added to the containers of the application where the builder supports it
+type BuildOptions struct {
+    Directory            string
+    ApplicationDirectory string
+    Release              string
+    NoBuild              bool
+    Labels               map[string]string
+    Manifest             *Manifest
+    Env                  []string
+}
+
+// function used to get application directory of build. applications
+// that are not deployed from releases are built in the application directory
+func (options BuildOptions) applicationDirectory() string {
+    if len(options.ApplicationDirectory) > 0 {
+        return options.ApplicationDirectory
+    }
+    return options.Directory
+}
+
+// interface used to build and tear down applications. builders are
+// selected per application or detected from the contents of the repo
+type Builder interface {
+    Name() string
+    Detect(options BuildOptions) bool
+    Build(ctx context.Context, options BuildOptions) error
+    Teardown(ctx context.Context, options BuildOptions) error
+    Containers(ctx context.Context, options BuildOptions) ([]string, error)
+}
+
+// define set of available builders. Note that the order of the builders
+// determines the precedence of builders when builders are auto-detected.
+// compose files take precedence to remain compatible with existing apps,
+// and Dockerfiles are checked last since they are commonly only used for CI
+var builders = []Builder{
+    ComposeBuilder{},
+    MakefileBuilder{},
+    ScriptBuilder{},
+    DockerfileBuilder{},
+}
+
+// function used to select builder for an application. builders set on
+// the registry entry take precedence over builders set in the manifest,
+// and builders are detected from the contents of the application
+// directory if neither are set or the entry builder is set to auto
+func selectBuilder(name string, options BuildOptions) (Builder, error) {
+    if (len(name) == 0 || name == BuilderAuto) && options.Manifest != nil {
+        name = options.Manifest.Builder
+    }
+    if len(name) == 0 || name == BuilderAuto {
+        for _, builder := range(builders) {
+            if builder.Detect(options) {
+                log.Info(fmt.Sprintf("detected %s builder for directory %s", builder.Name(), options.Directory))
+                return builder, nil
+            }
+        }
+        return nil, NoBuilderDetectedError
+    }
+    for _, builder := range(builders) {
+        if builder.Name() == name {
+            return builder, nil
+        }
+    }
+    return nil, UnknownBuilderError
+}
+
+// builder used to deploy docker-compose files. the compose files listed
+// in the manifest are deployed as a single stack if set, else each
+// compose file found in the repo is deployed as a separate stack
+type ComposeBuilder struct {}
+
+func (b ComposeBuilder) Name() string {
+    return BuilderCompose
+}
+
+func (b ComposeBuilder) Detect(options BuildOptions) bool {
+    stacks, err := b.stacks(options)
+    return err == nil && len(stacks) > 0
+}
+
+// function used to build and start compose stacks. all stacks are
+// built even if previous stacks fail, and the first error is returned
+func (b ComposeBuilder) Build(ctx context.Context, options BuildOptions) error {
+    return b.forEachStack(options, func(dir string, args []string) error {
+        if options.NoBuild {
+            return runCommand(ctx, dir, options.Env, "docker-compose", append(args, "up", "--no-build", "--detach", "--remove-orphans")...)
+        }
+        return runCommand(ctx, dir, options.Env, "docker-compose", append(args, "up", "--build", "--detach", "--remove-orphans")...)
+    })
+}
+
+func (b ComposeBuilder) Teardown(ctx context.Context, options BuildOptions) error {
+    return b.forEachStack(options, func(dir string, args []string) error {
+        return runCommand(ctx, dir, options.Env, "docker-compose", append(args, "down", "--remove-orphans")...)
+    })
+}
+
+// function used to list IDs of the containers of all compose stacks
+func (b ComposeBuilder) Containers(ctx context.Context, options BuildOptions) ([]string, error) {
+    containers := []string{}
+    err := b.forEachStack(options, func(dir string, args []string) error {
+        ids, err := commandOutputLines(ctx, dir, options.Env, "docker-compose", append(args, "ps", "-q")...)
+        containers = append(containers, ids...)
+        return err
+    })
+    return containers, err
+}
+
+// helper function used to determine the compose files of each stack
+func (b ComposeBuilder) stacks(options BuildOptions) ([][]string, error) {
+    if options.Manifest != nil && len(options.Manifest.ComposeFiles) > 0 {
+        files := []string{}
+        for _, file := range(options.Manifest.ComposeFiles) {
+            files = append(files, filepath.Join(options.Directory, file))
+        }
+        return [][]string{ files }, nil
+    }
+    paths, err := findDockerCompose(options.Directory)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to find docker-compose in directory %s: %v", options.Directory, err))
+        return nil, err
+    }
+    stacks := [][]string{}
+    for _, path := range(paths) {
+        stacks = append(stacks, []string{ path })
+    }
+    return stacks, nil
+}
+
+// helper function used to run function for each compose stack with the
+// directory of the first compose file and the compose arguments of the
+// stack. stacks of releases are deployed with an override file that tags
+// built images with the release, so that rollbacks start the images of
+// the release rather than the images that were built last, and that adds
+// the labels of the build options to all services
+func (b ComposeBuilder) forEachStack(options BuildOptions, fn func(dir string, args []string) error) error {
+    stacks, err := b.stacks(options)
+    if err != nil {
+        return err
+    }
+    log.Debug(fmt.Sprintf("found %d docker-compose stacks in directory %s", len(stacks), options.Directory))
+    var first error
+    for index, files := range(stacks) {
+        project := composeProjectName(options, filepath.Dir(files[0]), len(stacks))
+        if len(options.Release) > 0 || len(options.Labels) > 0 {
+            override, err := writeComposeOverride(files, project, options, index)
+            if err != nil {
+                log.Warn(fmt.Sprintf("unable to generate override for docker-compose stack %v: %v", files, err))
+            } else if len(override) > 0 {
+                files = append(files, override)
+            }
+        }
+        args := []string{}
+        for _, file := range(files) {
+            args = append(args, "-f", file)
+        }
+        args = append(args, "-p", project)
+        log.Debug(fmt.Sprintf("processing docker compose stack %v", files))
+        if err := fn(filepath.Dir(files[0]), args); err != nil {
+            log.Error(fmt.Errorf("unable to process docker-compose stack %v: %v", files, err))
+            if first == nil {
+                first = err
+            }
+        }
+    }
+    return first
+}
+
+// builder used to build Dockerfile at the root of a repo and run the
+// image as a single container named after the project name of the
+// manifest or the application directory. the env files of the manifest,
+// or the .env file if the repo has no manifest, are passed to the container
+type DockerfileBuilder struct {}
+
+func (b DockerfileBuilder) Name() string {
+    return BuilderDockerfile
+}
+
+func (b DockerfileBuilder) Detect(options BuildOptions) bool {
+    return isFile(filepath.Join(options.Directory, "Dockerfile"))
+}
+
+// function used to build and run image. images are tagged with the
+// release that they were built from, and are only built if NoBuild is not set
+func (b DockerfileBuilder) Build(ctx context.Context, options BuildOptions) error {
+    name := containerName(options)
+    tag := "latest"
+    if len(options.Release) > 0 {
+        tag = options.Release
+    }
+    image := fmt.Sprintf("go-get-git/%s:%s", name, tag)
+    if !options.NoBuild {
+        if err := runCommand(ctx, options.Directory, options.Env, "docker", "build", "--tag", image, "."); err != nil {
+            return err
+        }
+    }
+    // replace running container with container running new image
+    if err := b.Teardown(ctx, options); err != nil {
+        return err
+    }
+    args := []string{ "run", "--detach", "--name", name, "--restart", "unless-stopped" }
+    for key, value := range(options.Labels) {
+        args = append(args, "--label", fmt.Sprintf("%s=%s", key, value))
+    }
+    if options.Manifest != nil {
+        for _, file := range(options.Manifest.EnvFiles) {
+            args = append(args, "--env-file", file)
+        }
+    } else if isFile(filepath.Join(options.Directory, ".env")) {
+        args = append(args, "--env-file", ".env")
+    }
+    return runCommand(ctx, options.Directory, options.Env, "docker", append(args, image)...)
+}
+
+// function used to remove application container. containers that do
+// not exist are treated as successfully removed
+func (b DockerfileBuilder) Teardown(ctx context.Context, options BuildOptions) error {
+    name := containerName(options)
+    if err := exec.CommandContext(ctx, "docker", "inspect", "--type", "container", name).Run(); err != nil {
+        log.Debug(fmt.Sprintf("container %s does not exist. skipping removal", name))
+        return nil
+    }
+    return runCommand(ctx, options.Directory, options.Env, "docker", "rm", "--force", name)
+}
+
+func (b DockerfileBuilder) Containers(ctx context.Context, options BuildOptions) ([]string, error) {
+    return commandOutputLines(ctx, options.Directory, nil, "docker", "inspect", "--type", "container", "--format", "{{.Id}}", containerName(options))
+}
+
+// builder used to deploy applications with make targets. the deploy
+// target is required, while the teardown target is optional
+type MakefileBuilder struct {}
+
+func (b MakefileBuilder) Name() string {
+    return BuilderMakefile
+}
+
+func (b MakefileBuilder) Detect(options BuildOptions) bool {
+    return hasMakeTarget(options.Directory, MakeDeployTarget)
+}
+
+func (b MakefileBuilder) Build(ctx context.Context, options BuildOptions) error {
+    return runCommand(ctx, options.Directory, options.Env, "make", MakeDeployTarget)
+}
+
+func (b MakefileBuilder) Teardown(ctx context.Context, options BuildOptions) error {
+    if !hasMakeTarget(options.Directory, MakeTeardownTarget) {
+        log.Debug(fmt.Sprintf("makefile in directory %s has no %s target. skipping teardown", options.Directory, MakeTeardownTarget))
+        return nil
+    }
+    return runCommand(ctx, options.Directory, options.Env, "make", MakeTeardownTarget)
+}
+
+// function used to list containers of application. Note that containers
+// started by make targets cannot be determined, so no containers are returned
+func (b MakefileBuilder) Containers(ctx context.Context, options BuildOptions) ([]string, error) {
+    return []string{}, nil
+}
+
+// builder used to deploy applications with shell scripts at the root
+// of the repo. the deploy script is required, while the teardown script is optional
+type ScriptBuilder struct {}
+
+func (b ScriptBuilder) Name() string {
+    return BuilderScript
+}
+
+func (b ScriptBuilder) Detect(options BuildOptions) bool {
+    return isFile(filepath.Join(options.Directory, DeployScript))
+}
+
+func (b ScriptBuilder) Build(ctx context.Context, options BuildOptions) error {
+    return runCommand(ctx, options.Directory, options.Env, "sh", DeployScript)
+}
+
+func (b ScriptBuilder) Teardown(ctx context.Context, options BuildOptions) error {
+    if !isFile(filepath.Join(options.Directory, TeardownScript)) {
+        log.Debug(fmt.Sprintf("no %s script found in directory %s. skipping teardown", TeardownScript, options.Directory))
+        return nil
+    }
+    return runCommand(ctx, options.Directory, options.Env, "sh", TeardownScript)
+}
+
+// function used to list containers of application. Note that containers
+// started by scripts cannot be determined, so no containers are returned
+func (b ScriptBuilder) Containers(ctx context.Context, options BuildOptions) ([]string, error) {
+    return []string{}, nil
+}
+
+// helper function used to run command in directory with additional
+// environment variables. output of the command is captured in the
+// build log of the context and logged once the command has finished
+func runCommand(ctx context.Context, directory string, env []string, name string, args ...string) error {
+    cmd := exec.CommandContext(ctx, name, args...)
+    cmd.Dir = directory
+    cmd.Env = append(os.Environ(), env...)
+    output, err := runLogged(ctx, cmd)
+    if len(output) > 0 {
+        log.Info(output)
+    }
+    if err != nil {
+        return fmt.Errorf("command '%s %s' failed: %v", name, strings.Join(args, " "), err)
+    }
+    return nil
+}
+
+// helper function used to run command and return non-empty lines of stdout
+func commandOutputLines(ctx context.Context, directory string, env []string, name string, args ...string) ([]string, error) {
+    cmd := exec.CommandContext(ctx, name, args...)
+    cmd.Dir = directory
+    cmd.Env = append(os.Environ(), env...)
+    output, err := cmd.Output()
+    if err != nil {
+        return []string{}, fmt.Errorf("command '%s %s' failed: %v", name, strings.Join(args, " "), err)
+    }
+    lines := []string{}
+    for _, line := range(strings.Split(string(output), "\n")) {
+        if line = strings.TrimSpace(line); len(line) > 0 {
+            lines = append(lines, line)
+        }
+    }
+    return lines, nil
+}
+
+// helper function used to check if makefile in directory defines target
+func hasMakeTarget(directory, target string) bool {
+    file, err := os.Open(filepath.Join(directory, "Makefile"))
+    if err != nil {
+        return false
+    }
+    defer file.Close()
+    pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(target) + `\s*:([^=]|$)`)
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        if pattern.MatchString(scanner.Text()) {
+            return true
+        }
+    }
+    return false
+}
+
+// helper function used to generate docker container name of an
+// application. names are prefixed to avoid removing containers that
+// are not managed by go-get-git
+func containerName(options BuildOptions) string {
+    name := strings.ToLower(filepath.Base(filepath.Clean(options.applicationDirectory())))
+    if options.Manifest != nil && len(options.Manifest.ProjectName) > 0 {
+        name = options.Manifest.ProjectName
+    }
+    return "go-get-git-" + regexp.MustCompile(`[^a-z0-9_.-]+`).ReplaceAllString(name, "-")
+}
+
+// helper function used to generate compose project name of stack. the
+// project name of the manifest is used for repos with a single stack,
+// else the project is named after the directory of the stack in the
+// application directory, which matches the default project name that
+// docker-compose uses for applications that are not deployed from releases
+func composeProjectName(options BuildOptions, stackDirectory string, stacks int) string {
+    if options.Manifest != nil && len(options.Manifest.ProjectName) > 0 && stacks == 1 {
+        return options.Manifest.ProjectName
+    }
+    directory := options.applicationDirectory()
+    if relative, err := filepath.Rel(options.Directory, stackDirectory); err == nil {
+        directory = filepath.Join(directory, relative)
+    }
+    return regexp.MustCompile(`[^-_a-z0-9]+`).ReplaceAllString(strings.ToLower(filepath.Base(directory)), "")
+}
+
+// helper function used to generate compose override file that tags the
+// images of services built from the repo with the release and adds the
+// labels of the build options to all services. the override is written
+// next to the first compose file and its path is returned. Note that no
+// override is generated for legacy compose files without a version, since
+// services are not nested in these files
+func writeComposeOverride(files []string, project string, options BuildOptions, index int) (string, error) {
+    var version string
+    services := map[string]interface{}{}
+    for _, file := range(files) {
+        content, err := ioutil.ReadFile(file)
+        if err != nil {
+            return "", err
+        }
+        var compose struct {
+            Version  interface{}                       `yaml:"version"`
+            Services map[string]map[string]interface{} `yaml:"services"`
+        }
+        if err := yaml.Unmarshal(content, &compose); err != nil {
+            return "", fmt.Errorf("unable to parse compose file %s: %v", file, err)
+        }
+        if compose.Version != nil && len(version) == 0 {
+            version = fmt.Sprint(compose.Version)
+        }
+        for name, service := range(compose.Services) {
+            override := map[string]interface{}{}
+            if existing, ok := services[name].(map[string]interface{}); ok {
+                override = existing
+            }
+            if _, ok := service["build"]; ok && len(options.Release) > 0 {
+                override["image"] = fmt.Sprintf("go-get-git/%s-%s:%s", project, strings.ToLower(name), options.Release)
+            }
+            if len(options.Labels) > 0 {
+                override["labels"] = options.Labels
+            }
+            if len(override) > 0 {
+                services[name] = override
+            }
+        }
+    }
+    if len(version) == 0 || len(services) == 0 {
+        return "", nil
+    }
+    content, err := yaml.Marshal(map[string]interface{}{ "version": version, "services": services })
+    if err != nil {
+        return "", err
+    }
+    override := filepath.Join(filepath.Dir(files[0]), fmt.Sprintf(".go-get-git-compose-%d.yml", index))
+    return override, ioutil.WriteFile(override, content, 0644)
+}
+
+// function used to remove images that were built for release. images
+// are only removed once releases are pruned, so failures are only logged
+func removeReleaseImages(ctx context.Context, release string) {
+    images, err := commandOutputLines(ctx, "", nil, "docker", "images", "--quiet", "--filter", fmt.Sprintf("reference=go-get-git/*:%s", release))
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to list images of release %s: %v", release, err))
+        return
+    }
+    for _, image := range(images) {
+        if err := exec.CommandContext(ctx, "docker", "rmi", image).Run(); err != nil {
+            log.Warn(fmt.Sprintf("unable to remove image %s of release %s: %v", image, release, err))
+        }
+    }
+}
+
+// helper function used to check if path is a regular file
+func isFile(path string) bool {
+    info, err := os.Stat(path)
+    return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,198 @@
+package daemon
+
+import (
+    "fmt"
+    "io"
+    "bytes"
+    "sync"
+    "time"
+    "context"
+    "os/exec"
+    "strings"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// define streams that build log lines are captured from
+const (
+    StreamStdout = "stdout"
+    StreamStderr = "stderr"
+    StreamCommand = "command"
+)
+
+var (
+    // define how often and in which batch sizes build logs are shipped to the API
+    LogFlushInterval = time.Second
+    LogBatchSize = 200
+    MaxLogLineLength = 4096
+)
+
+type buildLogKey struct{}
+
+// struct used to capture output of the commands run during a deployment.
+// lines are numbered and timestamped as they are written, and are shipped
+// to the API in batches so that builds can be followed while they run
+type BuildLog struct {
+    entryId      uuid.UUID
+    deploymentId uuid.UUID
+    mutex        sync.Mutex
+    lines        []events.BuildLogLine
+    sequence     int64
+    done         chan struct{}
+    wg           sync.WaitGroup
+}
+
+// function used to create new build log for a deployment. lines are
+// flushed in the background until the build log is closed
+func NewBuildLog(entryId, deploymentId uuid.UUID) *BuildLog {
+    buildLog := &BuildLog{ entryId: entryId, deploymentId: deploymentId, done: make(chan struct{}) }
+    buildLog.wg.Add(1)
+    go func() {
+        defer buildLog.wg.Done()
+        ticker := time.NewTicker(LogFlushInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                buildLog.flush()
+            case <-buildLog.done:
+                return
+            }
+        }
+    }()
+    return buildLog
+}
+
+// function used to stop background flushes and flush remaining lines
+func (l *BuildLog) Close() {
+    if l == nil {
+        return
+    }
+    close(l.done)
+    l.wg.Wait()
+    l.flush()
+}
+
+// function used to add line to build log. Note that calls on nil
+// build logs are ignored so that commands can be run without build logs
+func (l *BuildLog) Append(stream, line string) {
+    if l == nil {
+        return
+    }
+    if len(line) > MaxLogLineLength {
+        line = line[:MaxLogLineLength] + "..."
+    }
+    l.mutex.Lock()
+    l.sequence++
+    l.lines = append(l.lines, events.BuildLogLine{ Sequence: l.sequence, Timestamp: time.Now().UTC(), Stream: stream, Line: line })
+    full := len(l.lines) >= LogBatchSize
+    l.mutex.Unlock()
+    if full {
+        l.flush()
+    }
+}
+
+// function used to create writer that appends each line written to a stream
+func (l *BuildLog) Writer(stream string) *LineWriter {
+    return &LineWriter{ log: l, stream: stream }
+}
+
+// function used to send buffered lines to the API. lines are
+// numbered so that the API can order and de-duplicate batches
+func (l *BuildLog) flush() {
+    l.mutex.Lock()
+    lines := l.lines
+    l.lines = nil
+    l.mutex.Unlock()
+    if len(lines) == 0 {
+        return
+    }
+    payload := events.BuildLogEvent{ EntryId: l.entryId, DeploymentId: l.deploymentId, Lines: lines }
+    if err := sendRabbitPayload(events.New("BuildLogEvent", ApplicationId, l.deploymentId, payload)); err != nil {
+        log.Error(fmt.Errorf("unable to send %d build log lines for deployment %s: %v", len(lines), l.deploymentId, err))
+    }
+}
+
+// writer used to split output of commands into build log lines. partial
+// lines are buffered until the next newline or until the writer is flushed
+type LineWriter struct {
+    log    *BuildLog
+    stream string
+    buffer bytes.Buffer
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+    if w.log == nil {
+        return len(p), nil
+    }
+    w.buffer.Write(p)
+    for {
+        line, err := w.buffer.ReadString('\n')
+        if err != nil {
+            // keep partial line in buffer until remaining output is written
+            w.buffer.Reset()
+            w.buffer.WriteString(line)
+            return len(p), nil
+        }
+        w.log.Append(w.stream, strings.TrimRight(line, "\r\n"))
+    }
+}
+
+// function used to append any remaining partial line to build log
+func (w *LineWriter) Flush() {
+    if w.log != nil && w.buffer.Len() > 0 {
+        w.log.Append(w.stream, strings.TrimRight(w.buffer.String(), "\r\n"))
+        w.buffer.Reset()
+    }
+}
+
+// function used to attach build log to context. all commands run with
+// the context are captured in the build log
+func withBuildLog(ctx context.Context, buildLog *BuildLog) context.Context {
+    return context.WithValue(ctx, buildLogKey{}, buildLog)
+}
+
+// function used to retrieve build log from context. nil is returned if
+// the context has no build log
+func buildLogFromContext(ctx context.Context) *BuildLog {
+    buildLog, _ := ctx.Value(buildLogKey{}).(*BuildLog)
+    return buildLog
+}
+
+// helper function used to run command and capture stdout and stderr of
+// the command in the build log of the context. the combined output of
+// the command is returned along with any errors
+func runLogged(ctx context.Context, cmd *exec.Cmd) (string, error) {
+    buildLog := buildLogFromContext(ctx)
+    buildLog.Append(StreamCommand, "$ " + strings.Join(cmd.Args, " "))
+
+    // note that stdout and stderr are copied concurrently, so writes to
+    // the combined output must be synchronized
+    output := &syncBuffer{}
+    stdout, stderr := buildLog.Writer(StreamStdout), buildLog.Writer(StreamStderr)
+    cmd.Stdout = io.MultiWriter(output, stdout)
+    cmd.Stderr = io.MultiWriter(output, stderr)
+    err := cmd.Run()
+    stdout.Flush()
+    stderr.Flush()
+    return output.String(), err
+}
+
+// buffer that can be written to from multiple goroutines
+type syncBuffer struct {
+    mutex  sync.Mutex
+    buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.buffer.String()
+}
@@ -0,0 +1,124 @@
+package daemon
+
+import (
+    "fmt"
+    "errors"
+    "context"
+    "strings"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "encoding/base64"
+    "encoding/json"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// define credentials that repos can be cloned with
+const (
+    CredentialNone = "none"
+    CredentialToken = "token"
+    CredentialDeployKey = "deploy_key"
+    // header used to authenticate against internal routes of the API
+    DaemonSecretHeader = "X-Go-Get-Git-Daemon-Secret"
+)
+
+var CredentialsUnavailableError = errors.New("unable to retrieve credentials from go-get-git API")
+
+// struct used to store credentials used to clone repos
+type GitCredentials struct {
+    CredentialType string `json:"credentialType"`
+    Username       string `json:"username"`
+    Token          string `json:"token"`
+    PrivateKey     string `json:"privateKey"`
+}
+
+// function used to fetch clone credentials of a repo entry from the
+// API. repos are cloned anonymously if no API URL is configured or if
+// the event was sent without an entry ID. Note that credentials are
+// fetched before each sync and are never persisted by the daemon
+func fetchCredentials(ctx context.Context, entryId uuid.UUID) (*GitCredentials, error) {
+    if len(ApiUrl) == 0 || entryId == uuid.Nil {
+        return nil, nil
+    }
+    endpoint := fmt.Sprintf("%s/internal/credentials/%s", strings.TrimSuffix(ApiUrl, "/"), entryId)
+    request, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+    if err != nil {
+        return nil, err
+    }
+    request.Header.Add(DaemonSecretHeader, DaemonSecret)
+    resp, err := http.DefaultClient.Do(request)
+    if err != nil {
+        log.Error(fmt.Errorf("unable to retrieve credentials for entry %s: %v", entryId, err))
+        return nil, CredentialsUnavailableError
+    }
+    defer resp.Body.Close()
+
+    body, _ := ioutil.ReadAll(resp.Body)
+    if resp.StatusCode != 200 {
+        log.Error(fmt.Sprintf("unable to retrieve credentials for entry %s: API returned code %d", entryId, resp.StatusCode))
+        return nil, CredentialsUnavailableError
+    }
+    var response struct {
+        Payload GitCredentials `json:"payload"`
+    }
+    if err := json.Unmarshal(body, &response); err != nil {
+        log.Error(fmt.Errorf("unable to parse credentials response: %v", err))
+        return nil, CredentialsUnavailableError
+    }
+    return &response.Payload, nil
+}
+
+// function used to generate the environment variables that git
+// commands are run with. tokens are passed as HTTP headers through
+// environment config so that they are never written to the git config
+// of the checkout, and deploy keys are written to a temporary key file
+// that is removed by the returned cleanup function
+func (credentials *GitCredentials) environment() ([]string, func(), error) {
+    // never prompt for credentials on the terminal of the daemon
+    env := []string{ "GIT_TERMINAL_PROMPT=0" }
+    cleanup := func() {}
+    if credentials == nil {
+        return env, cleanup, nil
+    }
+
+    switch credentials.CredentialType {
+    case CredentialToken:
+        auth := base64.StdEncoding.EncodeToString([]byte(credentials.Username + ":" + credentials.Token))
+        env = append(env,
+            "GIT_CONFIG_COUNT=1",
+            "GIT_CONFIG_KEY_0=http.extraHeader",
+            "GIT_CONFIG_VALUE_0=Authorization: Basic " + auth,
+        )
+    case CredentialDeployKey:
+        file, err := ioutil.TempFile("", "go-get-git-key-")
+        if err != nil {
+            return nil, cleanup, err
+        }
+        cleanup = func() {
+            if err := removeFile(file.Name()); err != nil {
+                log.Warn(fmt.Sprintf("unable to remove deploy key file %s: %v", file.Name(), err))
+            }
+        }
+        // note that temporary files are created with 0600 permissions
+        key := strings.TrimSpace(credentials.PrivateKey) + "\n"
+        if _, err := file.WriteString(key); err != nil {
+            file.Close()
+            cleanup()
+            return nil, func() {}, err
+        }
+        file.Close()
+        env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", file.Name()))
+    }
+    return env, cleanup, nil
+}
+
+// function used to convert HTTPS repo URLs into SSH clone URLs of
+// the form git@<host>:<owner>/<repo>.git used with deploy keys
+func sshCloneUrl(repoUrl string) string {
+    parsed, err := url.Parse(repoUrl)
+    if err != nil || len(parsed.Host) == 0 {
+        return repoUrl + ".git"
+    }
+    return fmt.Sprintf("git@%s:%s.git", parsed.Hostname(), strings.Trim(parsed.Path, "/"))
+}
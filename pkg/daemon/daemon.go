@@ -2,135 +2,742 @@ package daemon
 
 import (
     "fmt"
+    "context"
     "os"
-    "os/exec"
+    "path"
     "strings"
+    "time"
     "path/filepath"
+    "github.com/PSauerborn/go-get-git/pkg/broker"
     "github.com/PSauerborn/go-get-git/pkg/events"
-    rabbit "github.com/PSauerborn/go-jackrabbit"
+    "github.com/google/uuid"
     log "github.com/sirupsen/logrus"
 )
 
-// function used to create new daemon
+// function used to create new daemon. events are handled by a
+// scheduler so that slow deployments do not block other applications,
+// and processed events are recorded so that duplicates are skipped
 func New() *GoGetGitDaemon {
     ConfigureService()
-    return &GoGetGitDaemon{}
+    store, err := OpenEventStore(EventStorePath)
+    if err != nil {
+        log.Fatal(err)
+    }
+    scheduler := NewScheduler(DeployWorkers)
+    scheduler.Start(context.Background())
+    return &GoGetGitDaemon{ scheduler: scheduler, store: store }
 }
 
 // define struct used to control daemon
-type GoGetGitDaemon struct {}
+type GoGetGitDaemon struct {
+    scheduler *Scheduler
+    store     *EventStore
+}
 
-// function used to create go-get-git daemon
-func (daemon GoGetGitDaemon) Run() {
+// function used to run go-get-git daemon until the context is cancelled.
+// the daemon stops consuming events once the context is cancelled, and
+// running jobs are given the shutdown grace period to finish
+func (daemon GoGetGitDaemon) Run(ctx context.Context) {
     log.Info("starting new instance of GoGetGit Daemon")
-    config := rabbit.RabbitConnectionConfig{
-        QueueURL: RabbitQueueUrl,
-        QueueName: QueueName,
-        ExchangeName: EventExchangeName,
-        ExchangeType: ExchangeType,
+    if WatchContainers {
+        go NewContainerWatcher().Run(ctx)
     }
-    // start listening on rabbitMQ queue for events
-    err := rabbit.ListenOnQueueWithExchange(config, daemon.ProcessRabbitMessage)
-    if err != nil {
-        log.Fatal(fmt.Errorf("unable to create rabbitmq listener: %v", err))
+    go daemon.store.RunPruner(ctx, time.Duration(EventRetentionHours) * time.Hour, time.Hour)
+    // shut scheduler down once the context is cancelled. Note that the
+    // listener only returns once all received events have been settled,
+    // which requires discarded and cancelled jobs to settle their events
+    stopped := make(chan struct{})
+    go func() {
+        <-ctx.Done()
+        daemon.shutdown()
+        close(stopped)
+    }()
+    // start listening on rabbitMQ queue for events routed to this node
+    // and events broadcast to all daemons
+    consumer := consumerConfig(QueueName, broker.DaemonRoutingKey(NodeId), broker.DaemonRoutingKey(""))
+    if err := broker.Listen(ctx, exchangeConfig(), consumer, daemon.ProcessRabbitMessage); err != nil {
+        log.Error(fmt.Errorf("unable to stop rabbitmq listener: %v", err))
+    }
+    <-stopped
+    if err := daemon.store.Close(); err != nil {
+        log.Error(fmt.Errorf("unable to close processed events store: %v", err))
     }
 }
 
-// function used to define how rabbitMQ messages are handled
-func (daemon GoGetGitDaemon) ProcessRabbitMessage(payload []byte) {
-    log.Info(fmt.Sprintf("received rabbitmq message %v", string(payload)))
-    event, err := events.ParseEvent(payload)
+// helper function used to shut down scheduler of daemon. pending jobs
+// are discarded, and running jobs are cancelled if they do not finish
+// within the grace period. cancelled deployments restore the current release
+func (daemon GoGetGitDaemon) shutdown() {
+    grace := time.Duration(ShutdownGraceSeconds) * time.Second
+    log.Info(fmt.Sprintf("waiting up to %s for running jobs to finish", grace))
+    if daemon.scheduler.Shutdown(grace) {
+        log.Info("all running jobs finished")
+    } else {
+        log.Warn("running jobs were cancelled during shutdown")
+    }
+}
+
+// function used to define how rabbitMQ messages are handled. events
+// are submitted to the scheduler as jobs, which serializes events of
+// the same application and coalesces pending pushes. deliveries are
+// settled once their job has run, and requeued if the job is discarded
+// during shutdown so that the event is processed once the daemon restarts.
+// events are marked as completed once their job has succeeded, so that
+// redelivered events are skipped
+func (daemon GoGetGitDaemon) ProcessRabbitMessage(delivery *broker.Delivery) {
+    log.Info(fmt.Sprintf("received rabbitmq message %s (attempt %d)", delivery.MessageId, delivery.Attempt))
+    log.Debug(fmt.Sprintf("received rabbitmq message body %s", string(delivery.Body)))
+    event, err := events.ParseEvent(delivery.Body)
     if err != nil {
         log.Error(fmt.Errorf("unable to parse event: %s", err))
-    } else {
+        delivery.Retry(broker.Permanent(err))
+        return
+    }
+    settle := func(ctx context.Context, err error) {
+        if err == nil {
+            daemon.complete(event)
+        }
+        settleDelivery(ctx, delivery, err)
+    }
 
-        // handle incoming event based on event type
-        switch e := event.EventPayload.(type) {
-            // handle event triggered when new master push is triggered on git repo
-        case events.GitPushEvent:
-            log.Debug(fmt.Sprintf("processing new GitPushEvent %+v", e))
-            err := handleGitPushEvent(e)
-            if err != nil {
-                log.Error(fmt.Errorf("unable to process NewGitPush event: %v", err))
-            }
-            // handle event triggered when new application is registered
-        case events.NewGitRepoEvent:
-            log.Debug(fmt.Sprintf("processing new Git Application event %+v", e))
-            err := handleNewApplicationEvent(e)
-            if err != nil {
-                log.Error(fmt.Errorf("unable to process NewGitRepo event: %v", err))
-            }
-            // handle default case
-        default:
-            log.Debug(fmt.Sprintf("received event type '%+v'", e))
+    // handle incoming event based on event type
+    switch e := event.EventPayload.(type) {
+        // handle event triggered when new master push is triggered on git repo
+    case events.GitPushEvent:
+        log.Debug(fmt.Sprintf("processing new GitPushEvent %+v", e))
+        superseded := daemon.scheduler.Submit(Job{
+            Application: applicationKey(e.EntryId, e.ApplicationDirectory),
+            Description: fmt.Sprintf("GitPushEvent for directory %s at %s", e.ApplicationDirectory, getCheckoutTarget(e)),
+            Push: &e,
+            Run: daemon.runOnce(event, func(ctx context.Context) error {
+                return handleGitPushEvent(ctx, e)
+            }),
+            Settle: func(ctx context.Context, err error) {
+                // failed pushes are not retried once they have been superseded
+                if err != nil && ctx.Err() == nil && isRetryable(err) && daemon.superseded(e) {
+                    publishBuildSuperseded(e)
+                    err = nil
+                }
+                settle(ctx, err)
+            },
+            Discard: delivery.Requeue,
+        })
+        if superseded != nil {
+            publishBuildSuperseded(*superseded.Push)
+            superseded.Settle(context.Background(), nil)
+        }
+        // handle event triggered when new application is registered
+    case events.NewGitRepoEvent:
+        log.Debug(fmt.Sprintf("processing new Git Application event %+v", e))
+        daemon.scheduler.Submit(Job{
+            Application: applicationKey(e.EntryId, e.ApplicationDirectory),
+            Description: fmt.Sprintf("NewGitRepoEvent for directory %s", e.ApplicationDirectory),
+            Run: daemon.runOnce(event, func(ctx context.Context) error {
+                return handleNewApplicationEvent(ctx, e)
+            }),
+            Settle: settle,
+            Discard: delivery.Requeue,
+        })
+        // handle event triggered when application is removed
+    case events.RemoveGitRepoEvent:
+        log.Debug(fmt.Sprintf("processing new remove Git Application event %+v", e))
+        daemon.scheduler.Submit(Job{
+            Application: applicationKey(e.EntryId, e.ApplicationDirectory),
+            Description: fmt.Sprintf("RemoveGitRepoEvent for directory %s", e.ApplicationDirectory),
+            Run: daemon.runOnce(event, func(ctx context.Context) error {
+                return handleRemoveApplicationEvent(ctx, e)
+            }),
+            Settle: settle,
+            Discard: delivery.Requeue,
+        })
+        // handle event triggered when application is moved
+    case events.MoveGitRepoEvent:
+        log.Debug(fmt.Sprintf("processing new move Git Application event %+v", e))
+        daemon.scheduler.Submit(Job{
+            Application: applicationKey(e.EntryId, e.PreviousApplicationDirectory),
+            Description: fmt.Sprintf("MoveGitRepoEvent for directory %s", e.PreviousApplicationDirectory),
+            Run: daemon.runOnce(event, func(ctx context.Context) error {
+                return handleMoveApplicationEvent(ctx, e)
+            }),
+            Settle: settle,
+            Discard: delivery.Requeue,
+        })
+        // handle event triggered when application is rolled back
+    case events.RollbackEvent:
+        log.Debug(fmt.Sprintf("processing new rollback event %+v", e))
+        daemon.scheduler.Submit(Job{
+            Application: applicationKey(e.EntryId, e.ApplicationDirectory),
+            Description: fmt.Sprintf("RollbackEvent for directory %s", e.ApplicationDirectory),
+            Run: daemon.runOnce(event, func(ctx context.Context) error {
+                return handleRollbackEvent(ctx, e)
+            }),
+            Settle: settle,
+            Discard: delivery.Requeue,
+        })
+        // handle default case
+    default:
+        log.Debug(fmt.Sprintf("received event type '%+v'", e))
+        delivery.Ack()
+    }
+}
+
+// helper function used to run handler of event at most once. events that
+// have already been completed are skipped, and the progress of the event
+// is attached to the context so that handlers of redelivered events can
+// resume from the last completed step
+func (daemon GoGetGitDaemon) runOnce(event *events.Event, handler func(ctx context.Context) error) func(ctx context.Context) error {
+    return func(ctx context.Context) error {
+        progress, err := daemon.store.Start(event.EventId, event.EventType)
+        if err != nil {
+            log.Warn(fmt.Sprintf("unable to read processed events store: %v. processing event %s in full", err, event.EventId))
+            return handler(ctx)
+        }
+        if progress.Completed() {
+            log.Info(fmt.Sprintf("skipping duplicate %s %s that has already been processed", event.EventType, event.EventId))
+            return nil
         }
+        return handler(withEventProgress(ctx, progress))
     }
 }
 
-// helper function used to create new directory for application
-func handleNewApplicationEvent(event events.NewGitRepoEvent) error {
+// helper function used to mark event as completed. failures are only
+// logged, since duplicates of the event are processed again at worst
+func (daemon GoGetGitDaemon) complete(event *events.Event) {
+    if err := daemon.store.Complete(event.EventId, event.EventType); err != nil {
+        log.Warn(fmt.Sprintf("unable to mark event %s as completed: %v", event.EventId, err))
+    }
+}
+
+// helper function used to determine if push has been superseded by a push
+// that was triggered after it and has since been submitted or deployed
+func (daemon GoGetGitDaemon) superseded(event events.GitPushEvent) bool {
+    return daemon.scheduler.Superseded(applicationKey(event.EntryId, event.ApplicationDirectory), event) || isStalePush(event)
+}
+
+// function used to settle delivery of event once its job has run. jobs
+// cancelled during shutdown are requeued so that they run again once the
+// daemon has restarted, while failed jobs are retried with backoff unless
+// they failed at a step that retrying cannot fix
+func settleDelivery(ctx context.Context, delivery *broker.Delivery, err error) {
+    switch {
+    case err == nil:
+        delivery.Ack()
+    case ctx.Err() != nil:
+        delivery.Requeue()
+    case !isRetryable(err):
+        delivery.Retry(broker.Permanent(err))
+    default:
+        delivery.Retry(err)
+    }
+}
+
+// helper function used to determine if failed job should be retried.
+// invalid manifests, unknown builders and missing commits are permanent
+func isRetryable(err error) bool {
+    switch failedStep(err) {
+    case StepManifest, StepSelectBuilder, StepResolve:
+        return false
+    }
+    return true
+}
+
+// helper function used to clone new application into application directory
+func handleNewApplicationEvent(ctx context.Context, event events.NewGitRepoEvent) error {
     log.Info(fmt.Sprintf("processing new application directory for %s", event.ApplicationDirectory))
-    // create directory for new application
-    err := os.Mkdir(event.ApplicationDirectory, 0775)
+    credentials, err := fetchCredentials(ctx, event.EntryId)
     if err != nil {
-        log.Error(fmt.Errorf("unable to create new application directory: %v", err))
         return err
     }
-    // clone git repository into given directory
-    cmd := exec.Command(fmt.Sprintf("git clone %s.%s %s", event.RepoUrl, "git", event.ApplicationDirectory))
-    stdout, err := cmd.Output()
-    if len(stdout) > 0 {
-        log.Info(stdout)
+    releases := NewReleases(event.ApplicationDirectory)
+    if err := releases.Migrate(); err != nil {
+        log.Error(fmt.Errorf("unable to migrate application directory %s: %v", event.ApplicationDirectory, err))
+        return err
     }
-    if err != nil {
+    // clone git repository into repo directory and checkout default branch
+    workspace := NewWorkspace(event.RepoUrl, releases.RepoDirectory(), credentials)
+    if _, err := workspace.Sync(ctx, ""); err != nil {
         log.Error(fmt.Errorf("unable to clone git repo %s into directory %s: %v", event.RepoUrl, event.ApplicationDirectory, err))
         return err
     }
     return nil
 }
 
-// helper function used to handle new git push event
-func handleGitPushEvent(event events.GitPushEvent) error {
-    log.Info(fmt.Sprintf("processing new git push event for directory %s", event.ApplicationDirectory))
-    // clone git repository into given directory
-    cmd := exec.Command(fmt.Sprintf("git clone %s.%s %s", event.RepoUrl, "git", event.ApplicationDirectory))
-    stdout, err := cmd.Output()
-    if len(stdout) > 0 {
-        log.Info(stdout)
+// helper function used to tear down application and archive or
+// remove the application directory
+func handleRemoveApplicationEvent(ctx context.Context, event events.RemoveGitRepoEvent) error {
+    log.Info(fmt.Sprintf("removing application in directory %s", event.ApplicationDirectory))
+    if _, err := os.Stat(event.ApplicationDirectory); os.IsNotExist(err) {
+        log.Warn(fmt.Sprintf("application directory %s does not exist. skipping removal", event.ApplicationDirectory))
+        return nil
     }
+
+    // tear down current release of application before removing directory
+    options := loadTeardownOptions(event.ApplicationDirectory)
+    builder, err := selectBuilder(event.Builder, options)
     if err != nil {
-        log.Error(fmt.Errorf("unable to clone git repo %s into directory %s: %v", event.RepoUrl, event.ApplicationDirectory, err))
+        log.Warn(fmt.Sprintf("unable to select builder for directory %s: %v. skipping teardown", event.ApplicationDirectory, err))
+    } else if err := builder.Teardown(ctx, options); err != nil {
+        log.Error(fmt.Errorf("unable to tear down application in directory %s: %v", event.ApplicationDirectory, err))
         return err
     }
+    if history, err := NewReleases(event.ApplicationDirectory).History(); err == nil {
+        for _, release := range(history) {
+            removeReleaseImages(ctx, release)
+        }
+    }
 
-    // find path of docker compose files in directory
-    paths, err := findDockerCompose(event.ApplicationDirectory)
+    // remove application directory if no archive directory is configured
+    if len(ArchiveDirectory) == 0 {
+        log.Info(fmt.Sprintf("removing application directory %s", event.ApplicationDirectory))
+        return os.RemoveAll(event.ApplicationDirectory)
+    }
+    archive := filepath.Join(ArchiveDirectory, fmt.Sprintf("%s-%s", filepath.Base(event.ApplicationDirectory), time.Now().Format("20060102150405")))
+    log.Info(fmt.Sprintf("archiving application directory %s to %s", event.ApplicationDirectory, archive))
+    return os.Rename(event.ApplicationDirectory, archive)
+}
+
+// helper function used to move application to a new directory.
+// applications are torn down before the directory is moved and the
+// current release is rebuilt afterwards, since compose project and
+// container names are derived from the directory of the application
+func handleMoveApplicationEvent(ctx context.Context, event events.MoveGitRepoEvent) error {
+    log.Info(fmt.Sprintf("moving application directory %s to %s", event.PreviousApplicationDirectory, event.ApplicationDirectory))
+    progress := eventProgressFromContext(ctx)
+    // directories moved by a previous attempt of the event are only rebuilt
+    if step, _ := progress.Resume(); step == StepMove {
+        log.Info(fmt.Sprintf("application directory %s was moved by previous attempt. resuming build", event.ApplicationDirectory))
+    } else {
+        options := loadTeardownOptions(event.PreviousApplicationDirectory)
+        builder, err := selectBuilder(event.Builder, options)
+        if err != nil {
+            log.Warn(fmt.Sprintf("unable to select builder for directory %s: %v. skipping teardown", event.PreviousApplicationDirectory, err))
+        } else if err := builder.Teardown(ctx, options); err != nil {
+            log.Error(fmt.Errorf("unable to tear down application in directory %s: %v", event.PreviousApplicationDirectory, err))
+            return err
+        }
+        if err := os.Rename(event.PreviousApplicationDirectory, event.ApplicationDirectory); err != nil {
+            log.Error(fmt.Errorf("unable to move application directory: %v", err))
+            return err
+        }
+        progress.Checkpoint(StepMove, "")
+    }
+
+    options := loadTeardownOptions(event.ApplicationDirectory)
+    builder, err := selectBuilder(event.Builder, options)
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to select builder for directory %s: %v. skipping build", event.ApplicationDirectory, err))
+        return nil
+    }
+    options.Labels = managedLabels(event.EntryId, options.Release)
+    if err := deployApplication(ctx, builder, options); err != nil {
+        log.Error(fmt.Errorf("unable to build application in directory %s: %v", event.ApplicationDirectory, err))
+        return err
+    }
+    return nil
+}
+
+// helper function used to handle new git push event. pushes that were
+// triggered before the last deployed push, such as retried pushes, are
+// skipped so that they never replace the release of a newer push
+func handleGitPushEvent(ctx context.Context, event events.GitPushEvent) error {
+    log.Info(fmt.Sprintf("processing new git push event for directory %s", event.ApplicationDirectory))
+    if isStalePush(event) {
+        publishBuildSuperseded(event)
+        return nil
+    }
+    return runDeployment(ctx, event, func(ctx context.Context) (deployResult, error) {
+        return deployPushEvent(ctx, event)
+    })
+}
+
+// helper function used to determine if push was triggered before the
+// last push that was successfully deployed to the application directory
+func isStalePush(event events.GitPushEvent) bool {
+    deployed := NewReleases(event.ApplicationDirectory).DeployedTrigger()
+    return triggeredBefore(event, events.GitPushEvent{ TriggeredAt: deployed })
+}
+
+// helper function used to handle rollback event. Note that rollbacks are
+// reported with the same build events as deployments of push events
+func handleRollbackEvent(ctx context.Context, event events.RollbackEvent) error {
+    log.Info(fmt.Sprintf("processing new rollback event for directory %s", event.ApplicationDirectory))
+    return runDeployment(ctx, rollbackDeployment(event), func(ctx context.Context) (deployResult, error) {
+        return rollbackApplication(ctx, event)
+    })
+}
+
+// helper function used to convert rollback event into the deployment
+// that build events of the rollback are reported for
+func rollbackDeployment(event events.RollbackEvent) events.GitPushEvent {
+    return events.GitPushEvent{
+        EntryId: event.EntryId,
+        DeploymentId: event.DeploymentId,
+        RepoUrl: event.RepoUrl,
+        ApplicationDirectory: event.ApplicationDirectory,
+        CommitSha: event.CommitSha,
+        Builder: event.Builder,
+    }
+}
+
+// function used to run deployment. build events are published when the
+// deployment starts and once it has completed or failed
+func runDeployment(ctx context.Context, event events.GitPushEvent, deploy func(ctx context.Context) (deployResult, error)) error {
+    started := time.Now()
+    publishBuildEvent(event, "BuildTriggeredEvent", events.BuildTriggeredEvent{
+        EntryId: event.EntryId,
+        DeploymentId: event.DeploymentId,
+        RepoUrl: event.RepoUrl,
+        CommitSha: event.CommitSha,
+    })
+
+    // capture output of all commands run during deployment in build log. Note
+    // that the build log is closed before the result of the deployment is
+    // published so that all lines are shipped before the deployment finishes
+    var buildLog *BuildLog
+    if event.DeploymentId != uuid.Nil {
+        buildLog = NewBuildLog(event.EntryId, event.DeploymentId)
+        ctx = withBuildLog(ctx, buildLog)
+    }
+    result, err := deploy(ctx)
     if err != nil {
-        log.Error(fmt.Errorf("unable to find docker-compose in directory %s: %v", event.ApplicationDirectory, err))
+        log.Error(fmt.Errorf("deployment of directory %s failed at step %s: %v", event.ApplicationDirectory, failedStep(err), err))
+        buildLog.Append(StreamCommand, fmt.Sprintf("deployment failed at step %s: %v", failedStep(err), err))
+        buildLog.Close()
+        publishBuildFailed(event, result.CommitSha, started, err)
         return err
     }
+    buildLog.Close()
+    publishBuildEvent(event, "BuildCompletedEvent", events.BuildCompletedEvent{
+        EntryId: event.EntryId,
+        DeploymentId: event.DeploymentId,
+        RepoUrl: event.RepoUrl,
+        CommitSha: result.CommitSha,
+        ContainerIds: result.ContainerIds,
+        DurationMs: time.Since(started).Milliseconds(),
+        Skipped: result.Skipped,
+    })
+    return nil
+}
+
+// struct used to store result of a deployment
+type deployResult struct {
+    CommitSha    string
+    ContainerIds []string
+    Skipped      bool
+}
 
-    log.Debug(fmt.Sprintf("found %d docker-compose files to build", len(paths)))
-    // iterate over path(s) of docker compose files and build docker files
-    for _, path := range(paths) {
-        log.Debug(fmt.Sprintf("building new docker compose file at %s", path))
-        err := buildDockerComposeFile(path)
+// function used to deploy push event. the pushed commit is exported
+// into a new release, which is built with the builder of the entry or
+// the builder detected from the repo contents if no builder is set. the
+// current release is only switched once the release has been built
+// successfully. errors are returned along with the failing step. Note
+// that redelivered events reuse the release created by their previous
+// attempt, while the release is always rebuilt since the containers may
+// have been restored to the current release in the meantime
+func deployPushEvent(ctx context.Context, event events.GitPushEvent) (deployResult, error) {
+    var result deployResult
+    releases := NewReleases(event.ApplicationDirectory)
+    if err := releases.Migrate(); err != nil {
+        return result, &StepError{ Step: StepRelease, Err: err }
+    }
+    progress := eventProgressFromContext(ctx)
+    sha := ""
+    if step, release := progress.Resume(); step == StepRelease && releases.Exists(release) {
+        log.Info(fmt.Sprintf("resuming deployment of directory %s from release %s created by previous attempt", event.ApplicationDirectory, release))
+        sha = release
+    } else {
+        created, skipped, err := createPushRelease(ctx, releases, event)
+        result.CommitSha, result.Skipped = created, skipped
+        if err != nil || skipped {
+            return result, err
+        }
+        sha = created
+        progress.Checkpoint(StepRelease, sha)
+    }
+    result.CommitSha = sha
+    // load deployment manifest of release. repos without manifests are
+    // deployed with the builder of the entry or the builder detected from the repo
+    options, err := loadReleaseOptions(releases, event.EntryId, sha, false)
+    if err != nil {
+        return result, &StepError{ Step: StepManifest, Err: err }
+    }
+    builder, err := selectBuilder(event.Builder, options)
+    if err != nil {
+        return result, &StepError{ Step: StepSelectBuilder, Err: err }
+    }
+    log.Info(fmt.Sprintf("building release %s of application in directory %s with %s builder", sha, event.ApplicationDirectory, builder.Name()))
+    if err := deployApplication(ctx, builder, options); err != nil {
+        if ctx.Err() != nil {
+            err = restoreCancelledRelease(ctx, releases, event.EntryId, event.Builder, err)
+        }
+        return result, err
+    }
+    // verify that release is healthy before switching the current release,
+    // and restore the current release if configured and the check fails
+    if err := verifyHealth(ctx, builder, options, newHealthCheck(event.HealthCheckUrl, event.HealthCheckTimeout, options)); err != nil {
+        if ctx.Err() != nil {
+            err = restoreCancelledRelease(ctx, releases, event.EntryId, event.Builder, err)
+        } else if event.AutoRollback {
+            err = restoreCurrentRelease(ctx, releases, event.EntryId, event.Builder, err)
+        }
+        return result, err
+    }
+    if err := releases.Activate(sha); err != nil {
+        return result, &StepError{ Step: StepActivate, Err: err }
+    }
+    if err := releases.RecordDeployedTrigger(event.TriggeredAt); err != nil {
+        log.Warn(fmt.Sprintf("unable to record trigger time of release %s in directory %s: %v", sha, event.ApplicationDirectory, err))
+    }
+    pruneReleases(ctx, releases)
+
+    containers, err := builder.Containers(ctx, options)
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to list containers of application in directory %s: %v", event.ApplicationDirectory, err))
+    }
+    result.ContainerIds = containers
+    return result, nil
+}
+
+// function used to create release of pushed commit. the latest changes are
+// fetched and the specific commit, tag or ref requested by the deployment is
+// checked out. Note that commit SHAs are always preferred so that the pushed
+// commit is deployed even if newer commits have been pushed by the time the
+// event is processed. releases are not created for branches that are not
+// tracked by the deployment manifest, in which case skipped is returned
+func createPushRelease(ctx context.Context, releases *Releases, event events.GitPushEvent) (string, bool, error) {
+    credentials, err := fetchCredentials(ctx, event.EntryId)
+    if err != nil {
+        return "", false, &StepError{ Step: StepCredentials, Err: err }
+    }
+    workspace := NewWorkspace(event.RepoUrl, releases.RepoDirectory(), credentials)
+    sha, err := workspace.Sync(ctx, getCheckoutTarget(event))
+    if err != nil {
+        return "", false, err
+    }
+    if len(event.CommitSha) > 0 && !strings.HasPrefix(sha, event.CommitSha) {
+        return sha, false, &StepError{ Step: StepResolve, Err: fmt.Errorf("checked out commit %s does not match requested commit %s", sha, event.CommitSha) }
+    }
+    log.Info(fmt.Sprintf("deploying commit %s pushed by '%s' in directory %s: %s", sha, event.Pusher, event.ApplicationDirectory, event.HeadCommitMessage))
+
+    // check tracked branch of the deployment manifest in checkout before creating release
+    manifest, err := loadManifest(workspace.Directory)
+    if err != nil {
+        return sha, false, &StepError{ Step: StepManifest, Err: err }
+    }
+    if branch := strings.TrimPrefix(event.Ref, "refs/heads/"); manifest != nil && len(manifest.TrackedBranch) > 0 && branch != event.Ref {
+        if matched, _ := path.Match(manifest.TrackedBranch, branch); !matched {
+            log.Info(fmt.Sprintf("skipping deployment of branch %s not tracked by manifest in directory %s", branch, event.ApplicationDirectory))
+            return sha, true, nil
+        }
+    }
+
+    if _, err := releases.Create(ctx, workspace, sha); err != nil {
+        return sha, false, &StepError{ Step: StepRelease, Err: err }
+    }
+    return sha, false, nil
+}
+
+// function used to roll application back to a previous release. the
+// release is started without building images and without running the
+// pre-deploy and post-deploy commands of the manifest, and becomes the
+// current release once it has been started successfully
+func rollbackApplication(ctx context.Context, event events.RollbackEvent) (deployResult, error) {
+    var result deployResult
+    releases := NewReleases(event.ApplicationDirectory)
+    sha, err := releases.RollbackTarget(event.CommitSha)
+    if err != nil {
+        return result, &StepError{ Step: StepRelease, Err: err }
+    }
+    result.CommitSha = sha
+    options, err := loadReleaseOptions(releases, event.EntryId, sha, true)
+    if err != nil {
+        return result, &StepError{ Step: StepManifest, Err: err }
+    }
+    builder, err := selectBuilder(event.Builder, options)
+    if err != nil {
+        return result, &StepError{ Step: StepSelectBuilder, Err: err }
+    }
+    log.Info(fmt.Sprintf("rolling back application in directory %s to release %s with %s builder", event.ApplicationDirectory, sha, builder.Name()))
+    if err := deployApplication(ctx, builder, options); err != nil {
+        if ctx.Err() != nil {
+            err = restoreCancelledRelease(ctx, releases, event.EntryId, event.Builder, err)
+        }
+        return result, err
+    }
+    if err := verifyHealth(ctx, builder, options, newHealthCheck(event.HealthCheckUrl, event.HealthCheckTimeout, options)); err != nil {
+        if ctx.Err() != nil {
+            err = restoreCancelledRelease(ctx, releases, event.EntryId, event.Builder, err)
+        }
+        return result, err
+    }
+    if err := releases.Activate(sha); err != nil {
+        return result, &StepError{ Step: StepActivate, Err: err }
+    }
+
+    containers, err := builder.Containers(ctx, options)
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to list containers of application in directory %s: %v", event.ApplicationDirectory, err))
+    }
+    result.ContainerIds = containers
+    return result, nil
+}
+
+// function used to restore current release of application after a release
+// failed its health check or was cancelled. the returned error describes the
+// cause along with the result of the restore, and is reported at the step
+// of the cause regardless of whether the restore succeeded
+func restoreCurrentRelease(ctx context.Context, releases *Releases, entryId uuid.UUID, builderName string, cause error) error {
+    step := failedStep(cause)
+    sha, err := releases.Current()
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to restore release of directory %s: %v", releases.Directory, err))
+        return &StepError{ Step: step, Err: fmt.Errorf("%v. no previous release to restore", cause) }
+    }
+    log.Info(fmt.Sprintf("restoring release %s of application in directory %s", sha, releases.Directory))
+    options, err := loadReleaseOptions(releases, entryId, sha, true)
+    if err != nil {
+        return &StepError{ Step: step, Err: fmt.Errorf("%v. unable to restore release %s: %v", cause, sha, err) }
+    }
+    builder, err := selectBuilder(builderName, options)
+    if err == nil {
+        err = deployApplication(ctx, builder, options)
+    }
+    if err != nil {
+        log.Error(fmt.Errorf("unable to restore release %s of application in directory %s: %v", sha, releases.Directory, err))
+        return &StepError{ Step: step, Err: fmt.Errorf("%v. unable to restore release %s: %v", cause, sha, err) }
+    }
+    return &StepError{ Step: step, Err: fmt.Errorf("%v. restored release %s", cause, sha) }
+}
+
+// function used to restore current release of application after a
+// deployment was cancelled during shutdown. the release is restored with
+// a new context, since the context of the deployment has been cancelled
+func restoreCancelledRelease(ctx context.Context, releases *Releases, entryId uuid.UUID, builderName string, cause error) error {
+    restoreCtx, cancel := context.WithTimeout(withBuildLog(context.Background(), buildLogFromContext(ctx)), time.Duration(ShutdownRestoreSeconds) * time.Second)
+    defer cancel()
+    log.Warn(fmt.Sprintf("deployment of directory %s was cancelled: %v", releases.Directory, cause))
+    cause = &StepError{ Step: StepShutdown, Err: fmt.Errorf("deployment cancelled during shutdown: %v", cause) }
+    return restoreCurrentRelease(restoreCtx, releases, entryId, builderName, cause)
+}
+
+// function used to remove releases and images of releases that exceed
+// the number of kept releases. failures are only logged, since the
+// deployment has already succeeded when releases are pruned
+func pruneReleases(ctx context.Context, releases *Releases) {
+    removed, err := releases.Prune(KeepReleases)
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to prune releases of directory %s: %v", releases.Directory, err))
+    }
+    for _, release := range(removed) {
+        removeReleaseImages(ctx, release)
+    }
+}
+
+// function used to load build options of application from the deployment
+// manifest and env files of the repo. invalid manifests are returned as
+// ManifestError's so that they can be reported to repo owners
+func loadBuildOptions(directory string) (BuildOptions, error) {
+    options := BuildOptions{ Directory: directory }
+    manifest, err := loadManifest(directory)
+    if err != nil {
+        return options, err
+    }
+    if manifest != nil {
+        env, err := loadEnvFiles(directory, manifest.EnvFiles)
         if err != nil {
-            log.Error(fmt.Errorf("unable to build docker-compose file at %s: %v", path, err))
+            return options, err
+        }
+        options.Manifest, options.Env = manifest, env
+    }
+    return options, nil
+}
+
+// function used to load build options of release. containers are labelled
+// as managed by go-get-git so that they are watched by the daemon. the entry
+// ID, the commit SHA of the release and whether images are rebuilt are also
+// exposed to make targets and scripts as environment variables
+func loadReleaseOptions(releases *Releases, entryId uuid.UUID, sha string, noBuild bool) (BuildOptions, error) {
+    options, err := loadBuildOptions(releases.Path(sha))
+    options.ApplicationDirectory = releases.Directory
+    options.Release = sha
+    options.NoBuild = noBuild
+    options.Labels = managedLabels(entryId, sha)
+    options.Env = append(options.Env, "GO_GET_GIT_ENTRY_ID=" + entryId.String(), "GO_GET_GIT_RELEASE=" + sha, fmt.Sprintf("GO_GET_GIT_NO_BUILD=%t", noBuild))
+    return options, err
+}
+
+// function used to load build options used to tear down the current
+// release of an application. Note that invalid manifests are ignored,
+// since applications must be torn down even if the manifest is broken
+func loadTeardownOptions(application string) BuildOptions {
+    releases := NewReleases(application)
+    directory := releases.CurrentDirectory()
+    options, err := loadBuildOptions(directory)
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to load deployment manifest in directory %s: %v. tearing down without manifest", directory, err))
+        options = BuildOptions{ Directory: directory }
+    }
+    options.ApplicationDirectory = application
+    if sha, err := releases.Current(); err == nil {
+        options.Release = sha
+    }
+    return options
+}
+
+// function used to deploy application with builder. the pre-deploy
+// commands of the manifest are run before the build, and post-deploy
+// commands are only run once the build has succeeded. Note that the
+// commands are not run if the application is started without building,
+// since releases that are rolled back to have already been deployed once.
+// deployments are cancelled if they do not finish within the timeout of
+// the manifest or the default deploy timeout
+func deployApplication(ctx context.Context, builder Builder, options BuildOptions) error {
+    timeout := time.Duration(DeployTimeoutMinutes) * time.Minute
+    if options.Manifest != nil && options.Manifest.Timeout > 0 {
+        timeout = time.Duration(options.Manifest.Timeout)
+    }
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    if options.Manifest != nil && !options.NoBuild {
+        for _, command := range(options.Manifest.PreDeploy) {
+            log.Info(fmt.Sprintf("running pre-deploy command '%s' in directory %s", command, options.Directory))
+            if err := runCommand(ctx, options.Directory, options.Env, "sh", "-c", command); err != nil {
+                return &StepError{ Step: StepPreDeploy, Err: fmt.Errorf("pre-deploy command failed: %v", err) }
+            }
+        }
+    }
+    if err := builder.Build(ctx, options); err != nil {
+        if ctx.Err() == context.DeadlineExceeded {
+            err = fmt.Errorf("deployment timed out after %s: %v", timeout, err)
+        }
+        return &StepError{ Step: StepBuild, Err: err }
+    }
+    if options.Manifest != nil && !options.NoBuild {
+        for _, command := range(options.Manifest.PostDeploy) {
+            log.Info(fmt.Sprintf("running post-deploy command '%s' in directory %s", command, options.Directory))
+            if err := runCommand(ctx, options.Directory, options.Env, "sh", "-c", command); err != nil {
+                return &StepError{ Step: StepPostDeploy, Err: fmt.Errorf("post-deploy command failed: %v", err) }
+            }
         }
     }
     return nil
 }
 
-// helper function used to build new docker compose file
-func buildDockerComposeFile(path string) error {
-    // build docker compose file
-    cmd := exec.Command(fmt.Sprintf("docker-compose run -f %s --build --remove-orphans -d", path))
-    stdout, err := cmd.Output()
-    if len(stdout) > 0 {
-        log.Info(stdout)
+// helper function used to determine which revision a push event should
+// be deployed at. commit SHAs take precedence over tags and refs, and
+// branch refs are resolved against the remote branches of the checkout
+func getCheckoutTarget(event events.GitPushEvent) string {
+    switch {
+    case len(event.CommitSha) > 0:
+        return event.CommitSha
+    case len(event.Tag) > 0:
+        return "refs/tags/" + event.Tag
+    case strings.HasPrefix(event.Ref, "refs/heads/"):
+        return "refs/remotes/origin/" + strings.TrimPrefix(event.Ref, "refs/heads/")
+    default:
+        return event.Ref
     }
-    return err
 }
 
 // helper function used to travers directory and find all docker compose files
@@ -138,6 +745,13 @@ func findDockerCompose(directory string) ([]string, error) {
     composeFiles := []string{}
     // walk through directory and find all docker compose files
     err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        // skip git metadata of checkouts
+        if info.IsDir() && info.Name() == ".git" {
+            return filepath.SkipDir
+        }
         if info.IsDir() {
             return nil
         }
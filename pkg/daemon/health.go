@@ -0,0 +1,120 @@
+package daemon
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "strings"
+    "net/http"
+    log "github.com/sirupsen/logrus"
+)
+
+// define interval that health checks are polled at
+var HealthCheckInterval = 2 * time.Second
+
+// struct used to define how deployments are verified once built. the
+// containers of the application must be running and healthy, and the
+// URL must respond with a successful status code if set
+type HealthCheck struct {
+    Url     string
+    Timeout time.Duration
+}
+
+// function used to define health check of deployment. the URL of the
+// entry takes precedence over the URL of the manifest, and the timeout
+// of the entry takes precedence over the default timeout
+func newHealthCheck(url string, timeoutSeconds int, options BuildOptions) HealthCheck {
+    check := HealthCheck{ Url: url, Timeout: time.Duration(HealthCheckTimeoutSeconds) * time.Second }
+    if len(check.Url) == 0 && options.Manifest != nil {
+        check.Url = options.Manifest.HealthCheckUrl
+    }
+    if timeoutSeconds > 0 {
+        check.Timeout = time.Duration(timeoutSeconds) * time.Second
+    }
+    return check
+}
+
+// function used to wait until application is healthy. checks are
+// retried until they pass or the timeout expires, in which case the
+// reason of the last failed check is returned
+func verifyHealth(ctx context.Context, builder Builder, options BuildOptions, check HealthCheck) error {
+    ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+    defer cancel()
+    log.Info(fmt.Sprintf("verifying health of application in directory %s", options.applicationDirectory()))
+
+    ticker := time.NewTicker(HealthCheckInterval)
+    defer ticker.Stop()
+    for {
+        reason := checkHealth(ctx, builder, options, check)
+        if len(reason) == 0 {
+            log.Info(fmt.Sprintf("application in directory %s is healthy", options.applicationDirectory()))
+            return nil
+        }
+        log.Debug(fmt.Sprintf("application in directory %s is not healthy yet: %s", options.applicationDirectory(), reason))
+        select {
+        case <-ctx.Done():
+            return &StepError{ Step: StepHealthCheck, Err: fmt.Errorf("application not healthy after %s: %s", check.Timeout, reason) }
+        case <-ticker.C:
+        }
+    }
+}
+
+// helper function used to run health checks once. the reason that the
+// application is not healthy is returned, or an empty string if healthy
+func checkHealth(ctx context.Context, builder Builder, options BuildOptions, check HealthCheck) string {
+    containers, err := builder.Containers(ctx, options)
+    if err != nil {
+        return fmt.Sprintf("unable to list containers: %v", err)
+    }
+    for _, container := range(containers) {
+        if reason := checkContainerHealth(ctx, container); len(reason) > 0 {
+            return reason
+        }
+    }
+    if len(check.Url) > 0 {
+        return checkUrlHealth(ctx, check.Url)
+    }
+    return ""
+}
+
+// helper function used to check state of container. containers must be
+// running and healthy if they define a health check, while containers
+// that have exited successfully are treated as one-off jobs
+func checkContainerHealth(ctx context.Context, container string) string {
+    output, err := commandOutputLines(ctx, "", nil, "docker", "inspect", "--type", "container", "--format",
+        "{{.Name}} {{.State.Status}} {{.State.ExitCode}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", container)
+    if err != nil || len(output) == 0 {
+        return fmt.Sprintf("unable to inspect container %s: %v", container, err)
+    }
+    fields := strings.Fields(output[0])
+    if len(fields) < 3 {
+        return fmt.Sprintf("unable to inspect container %s", container)
+    }
+    name, status, exitCode := strings.TrimPrefix(fields[0], "/"), fields[1], fields[2]
+    switch {
+    case status == "exited" && exitCode == "0":
+        return ""
+    case status != "running":
+        return fmt.Sprintf("container %s is %s with exit code %s", name, status, exitCode)
+    case len(fields) > 3 && fields[3] != "healthy":
+        return fmt.Sprintf("container %s is %s", name, fields[3])
+    }
+    return ""
+}
+
+// helper function used to probe health check URL of application
+func checkUrlHealth(ctx context.Context, url string) string {
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return fmt.Sprintf("invalid health check URL %s: %v", url, err)
+    }
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        return fmt.Sprintf("health check request to %s failed: %v", url, err)
+    }
+    defer response.Body.Close()
+    if response.StatusCode >= 400 {
+        return fmt.Sprintf("health check request to %s returned status code %d", url, response.StatusCode)
+    }
+    return ""
+}
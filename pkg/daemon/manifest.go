@@ -0,0 +1,165 @@
+package daemon
+
+import (
+    "fmt"
+    "os"
+    "bufio"
+    "reflect"
+    "regexp"
+    "strings"
+    "time"
+    "io/ioutil"
+    "path/filepath"
+    "github.com/go-playground/validator"
+    "gopkg.in/yaml.v2"
+)
+
+// name of the manifest file that repos can use to declare how they are deployed
+const ManifestFile = ".go-get-git.yaml"
+
+var (
+    validate = newManifestValidator()
+    projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// struct used to store deployment manifest of a repo. all paths are
+// relative to the root of the repo and must not point outside the repo
+type Manifest struct {
+    Version        int      `yaml:"version" validate:"omitempty,eq=1"`
+    Builder        string   `yaml:"builder" validate:"omitempty,oneof=compose dockerfile makefile script"`
+    ComposeFiles   []string `yaml:"compose_files" validate:"dive,required,repopath"`
+    ProjectName    string   `yaml:"project_name" validate:"omitempty,max=63,projectname"`
+    EnvFiles       []string `yaml:"env_files" validate:"dive,required,repopath"`
+    PreDeploy      []string `yaml:"pre_deploy" validate:"dive,required"`
+    PostDeploy     []string `yaml:"post_deploy" validate:"dive,required"`
+    HealthCheckUrl string   `yaml:"health_check_url" validate:"omitempty,url"`
+    Timeout        Duration `yaml:"timeout" validate:"gte=0"`
+    TrackedBranch  string   `yaml:"tracked_branch"`
+}
+
+// type used to parse durations such as 90s or 10m from manifests
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var value string
+    if err := unmarshal(&value); err != nil {
+        return err
+    }
+    parsed, err := time.ParseDuration(value)
+    if err != nil {
+        return fmt.Errorf("invalid duration '%s'", value)
+    }
+    *d = Duration(parsed)
+    return nil
+}
+
+// struct used to report invalid manifests. errors are reported as
+// failed builds, so messages must be readable by repo owners
+type ManifestError struct {
+    Problems []string
+}
+
+func (e *ManifestError) Error() string {
+    return fmt.Sprintf("invalid deployment manifest %s: %s", ManifestFile, strings.Join(e.Problems, "; "))
+}
+
+// function used to load deployment manifest from directory. nil is
+// returned without error if the repo does not contain a manifest
+func loadManifest(directory string) (*Manifest, error) {
+    content, err := ioutil.ReadFile(filepath.Join(directory, ManifestFile))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    return parseManifest(content)
+}
+
+// function used to parse and validate deployment manifest. Note that
+// unknown keys are rejected to catch typos in manifests
+func parseManifest(content []byte) (*Manifest, error) {
+    var manifest Manifest
+    if err := yaml.UnmarshalStrict(content, &manifest); err != nil {
+        // flatten multi-line yaml errors into a single line
+        message := strings.Join(strings.Fields(strings.TrimPrefix(err.Error(), "yaml: ")), " ")
+        return nil, &ManifestError{ Problems: []string{ message } }
+    }
+    if err := validate.Struct(manifest); err != nil {
+        validationErrors, ok := err.(validator.ValidationErrors)
+        if !ok {
+            return nil, &ManifestError{ Problems: []string{ err.Error() } }
+        }
+        problems := []string{}
+        for _, fieldErr := range(validationErrors) {
+            problems = append(problems, describeValidationError(fieldErr))
+        }
+        return nil, &ManifestError{ Problems: problems }
+    }
+    return &manifest, nil
+}
+
+// function used to load environment variables from the env files
+// listed in the manifest. files contain KEY=VALUE pairs, and empty
+// lines and lines starting with # are ignored
+func loadEnvFiles(directory string, files []string) ([]string, error) {
+    env := []string{}
+    for _, name := range(files) {
+        file, err := os.Open(filepath.Join(directory, name))
+        if err != nil {
+            return nil, &ManifestError{ Problems: []string{ fmt.Sprintf("env file %s cannot be read", name) } }
+        }
+        scanner := bufio.NewScanner(file)
+        for line := 1; scanner.Scan(); line++ {
+            value := strings.TrimSpace(scanner.Text())
+            if len(value) == 0 || strings.HasPrefix(value, "#") {
+                continue
+            }
+            value = strings.TrimPrefix(value, "export ")
+            if !strings.Contains(value, "=") {
+                file.Close()
+                return nil, &ManifestError{ Problems: []string{ fmt.Sprintf("env file %s has invalid line %d", name, line) } }
+            }
+            env = append(env, value)
+        }
+        file.Close()
+    }
+    return env, nil
+}
+
+// helper function used to generate readable message from validation errors
+func describeValidationError(err validator.FieldError) string {
+    field := strings.TrimPrefix(err.Namespace(), "Manifest.")
+    switch err.Tag() {
+    case "required":
+        return fmt.Sprintf("%s must not be empty", field)
+    case "repopath":
+        return fmt.Sprintf("%s must be a relative path inside the repo", field)
+    case "projectname":
+        return fmt.Sprintf("%s must only contain lowercase letters, digits, dashes and underscores", field)
+    case "oneof":
+        return fmt.Sprintf("%s must be one of %s", field, err.Param())
+    case "url":
+        return fmt.Sprintf("%s must be a valid URL", field)
+    case "gte":
+        return fmt.Sprintf("%s must not be negative", field)
+    default:
+        return fmt.Sprintf("%s failed '%s' validation", field, err.Tag())
+    }
+}
+
+// helper function used to create validator for manifests. fields are
+// reported with the names of the yaml keys in validation errors
+func newManifestValidator() *validator.Validate {
+    v := validator.New()
+    v.RegisterTagNameFunc(func(field reflect.StructField) string {
+        return strings.SplitN(field.Tag.Get("yaml"), ",", 2)[0]
+    })
+    v.RegisterValidation("repopath", func(fl validator.FieldLevel) bool {
+        path := fl.Field().String()
+        return !filepath.IsAbs(path) && !strings.HasPrefix(filepath.Clean(path), "..")
+    })
+    v.RegisterValidation("projectname", func(fl validator.FieldLevel) bool {
+        return projectNamePattern.MatchString(fl.Field().String())
+    })
+    return v
+}
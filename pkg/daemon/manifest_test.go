@@ -0,0 +1,43 @@
+package daemon
+
+import (
+    "time"
+    "testing"
+)
+
+func TestParseManifest(t *testing.T) {
+    tests := []struct {
+        name     string
+        content  string
+        valid    bool
+        builder  string
+        timeout  time.Duration
+    }{
+        { "empty manifest", "", true, "", 0 },
+        {
+            "complete manifest",
+            "version: 1\nbuilder: compose\ncompose_files: [docker-compose.yml, deploy/prod.yml]\nproject_name: my-app\nenv_files: [.env]\npre_deploy: [make migrate]\nhealth_check_url: http://localhost:8080/health\ntimeout: 10m\ntracked_branch: release/*\n",
+            true, "compose", 10 * time.Minute,
+        },
+        { "unknown key", "buidler: compose\n", false, "", 0 },
+        { "compose file outside repo", "compose_files: [../docker-compose.yml]\n", false, "", 0 },
+        { "negative timeout", "timeout: -1m\n", false, "", 0 },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            manifest, err := parseManifest([]byte(test.content))
+            if !test.valid {
+                if _, ok := err.(*ManifestError); !ok {
+                    t.Fatalf("expected manifest error, got %v", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("unable to parse manifest: %v", err)
+            }
+            if manifest.Builder != test.builder || time.Duration(manifest.Timeout) != test.timeout {
+                t.Errorf("expected builder '%s' with timeout %s, got %+v", test.builder, test.timeout, manifest)
+            }
+        })
+    }
+}
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+    "fmt"
+    "time"
+    "encoding/json"
+    "github.com/PSauerborn/go-get-git/pkg/broker"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// define steps that deployments can fail at in addition to workspace steps
+const (
+    StepManifest = "manifest"
+    StepRelease = "release"
+    StepActivate = "activate"
+    StepSelectBuilder = "select_builder"
+    StepPreDeploy = "pre_deploy"
+    StepBuild = "build"
+    StepPostDeploy = "post_deploy"
+    StepHealthCheck = "health_check"
+    StepShutdown = "shutdown"
+    StepMove = "move"
+)
+
+// struct used to report the step of a deployment that an error occurred at
+type StepError struct {
+    Step string
+    Err  error
+}
+
+func (e *StepError) Error() string {
+    return e.Err.Error()
+}
+
+func (e *StepError) Unwrap() error {
+    return e.Err
+}
+
+// function used to determine which step of a deployment an error occurred at
+func failedStep(err error) string {
+    switch e := err.(type) {
+    case *StepError:
+        return e.Step
+    case *WorkspaceError:
+        return e.Step
+    case *ManifestError:
+        return StepManifest
+    default:
+        return StepBuild
+    }
+}
+
+// function used to publish build event of a deployment over the event
+// exchange. Note that events are only published for push events that
+// were sent with a deployment, and failures to publish are only logged
+func publishBuildEvent(event events.GitPushEvent, eventType string, payload interface{}) {
+    if event.DeploymentId == uuid.Nil {
+        log.Debug(fmt.Sprintf("skipping %s for push event without deployment ID", eventType))
+        return
+    }
+    if err := sendRabbitPayload(events.New(eventType, ApplicationId, event.DeploymentId, payload)); err != nil {
+        log.Error(fmt.Errorf("unable to publish %s for deployment %s: %v", eventType, event.DeploymentId, err))
+    }
+}
+
+// function used to publish failed build of deployment
+func publishBuildFailed(event events.GitPushEvent, sha string, started time.Time, err error) {
+    publishBuildEvent(event, "BuildFailedEvent", events.BuildFailedEvent{
+        EntryId: event.EntryId,
+        DeploymentId: event.DeploymentId,
+        RepoUrl: event.RepoUrl,
+        CommitSha: sha,
+        Error: err.Error(),
+        FailedStep: failedStep(err),
+        DurationMs: time.Since(started).Milliseconds(),
+    })
+}
+
+// function used to report push event that was coalesced with a newer
+// push of the same application before it was built. deployments of
+// superseded pushes are reported as skipped
+func publishBuildSuperseded(event events.GitPushEvent) {
+    log.Info(fmt.Sprintf("skipping deployment %s superseded by newer push to directory %s", event.DeploymentId, event.ApplicationDirectory))
+    publishBuildEvent(event, "BuildCompletedEvent", events.BuildCompletedEvent{
+        EntryId: event.EntryId,
+        DeploymentId: event.DeploymentId,
+        RepoUrl: event.RepoUrl,
+        Skipped: true,
+    })
+}
+
+// function used to send event to the API over event exchange
+func sendRabbitPayload(event events.Event) error {
+    body, _ := json.Marshal(&event)
+    return broker.Publish(exchangeConfig(), broker.ApiRoutingKey, event.EventId.String(), body)
+}
+
+// helper function used to generate config of consumer listening on queue
+func consumerConfig(queue string, routingKeys ...string) broker.ConsumerConfig {
+    return broker.ConsumerConfig{
+        Queue: queue,
+        RoutingKeys: routingKeys,
+        Prefetch: Prefetch,
+        MaxAttempts: MaxDeliveryAttempts,
+        RetryBackoff: time.Duration(RetryBackoffSeconds) * time.Second,
+        MaxRetryBackoff: time.Duration(MaxRetryBackoffSeconds) * time.Second,
+        DeadLetterQueue: DeadLetterQueue,
+    }
+}
+
+// helper function used to generate config of event exchange
+func exchangeConfig() broker.ExchangeConfig {
+    return broker.ExchangeConfig{
+        Url: RabbitQueueUrl,
+        ExchangeName: EventExchangeName,
+        ExchangeType: ExchangeType,
+    }
+}
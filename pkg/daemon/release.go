@@ -0,0 +1,327 @@
+package daemon
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "bufio"
+    "errors"
+    "time"
+    "context"
+    "strings"
+    "io/ioutil"
+    "path/filepath"
+    log "github.com/sirupsen/logrus"
+)
+
+// define layout of application directories. the repo is checked out
+// into the repo directory, each deployed commit is exported into its
+// own release directory, and the current symlink points to the release
+// that is currently deployed. files in the shared directory are linked
+// into every release, and are used to store env files and other state
+// that is not part of the repo
+const (
+    RepoDirectory = "repo"
+    ReleasesDirectory = "releases"
+    SharedDirectory = "shared"
+    CurrentRelease = "current"
+    // file used to store the order that releases were created in
+    releaseHistoryFile = ".history"
+    // file used to store the trigger time of the last deployed push
+    deployedTriggerFile = ".triggered"
+)
+
+var (
+    NoCurrentReleaseError = errors.New("application has no current release")
+    NoPreviousReleaseError = errors.New("application has no previous release to roll back to")
+    UnknownReleaseError = errors.New("release does not exist")
+)
+
+// struct used to manage the releases of an application directory
+type Releases struct {
+    Directory string
+}
+
+// function used to create releases of application directory
+func NewReleases(directory string) *Releases {
+    return &Releases{ Directory: directory }
+}
+
+// function used to get directory that repo is checked out into
+func (r *Releases) RepoDirectory() string {
+    return filepath.Join(r.Directory, RepoDirectory)
+}
+
+// function used to get directory of release with given commit SHA
+func (r *Releases) Path(sha string) string {
+    return filepath.Join(r.Directory, ReleasesDirectory, sha)
+}
+
+// function used to get directory that the current release of the application
+// is deployed from. applications that have not been deployed since releases
+// were introduced are deployed from the application directory itself, and
+// the repo checkout is used if no release has been deployed successfully
+func (r *Releases) CurrentDirectory() string {
+    if sha, err := r.Current(); err == nil {
+        return r.Path(sha)
+    }
+    if _, err := os.Stat(filepath.Join(r.Directory, ".git")); err == nil {
+        return r.Directory
+    }
+    return r.RepoDirectory()
+}
+
+// function used to get commit SHA of current release
+func (r *Releases) Current() (string, error) {
+    target, err := os.Readlink(filepath.Join(r.Directory, CurrentRelease))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return "", NoCurrentReleaseError
+        }
+        return "", err
+    }
+    return filepath.Base(target), nil
+}
+
+// function used to move checkouts of applications that were deployed in
+// place into the repo directory. Note that the checkout is moved into a
+// temporary directory first, since the repo may contain a repo directory
+func (r *Releases) Migrate() error {
+    if _, err := os.Stat(filepath.Join(r.Directory, ".git")); err != nil {
+        return nil
+    }
+    log.Info(fmt.Sprintf("migrating checkout in directory %s to release layout", r.Directory))
+    entries, err := ioutil.ReadDir(r.Directory)
+    if err != nil {
+        return err
+    }
+    staging := filepath.Join(r.Directory, ".go-get-git-migrate")
+    if err := os.Mkdir(staging, 0755); err != nil {
+        return err
+    }
+    for _, entry := range(entries) {
+        if err := os.Rename(filepath.Join(r.Directory, entry.Name()), filepath.Join(staging, entry.Name())); err != nil {
+            return err
+        }
+    }
+    return os.Rename(staging, r.RepoDirectory())
+}
+
+// function used to create release of commit from workspace checkout. the
+// commit is exported into a staging directory that is only renamed once
+// complete, so existing release directories can always be reused
+func (r *Releases) Create(ctx context.Context, workspace *Workspace, sha string) (string, error) {
+    release := r.Path(sha)
+    if _, err := os.Stat(release); err == nil {
+        log.Info(fmt.Sprintf("reusing existing release %s", release))
+        return release, r.record(sha)
+    }
+    if err := os.MkdirAll(filepath.Dir(release), 0755); err != nil {
+        return "", err
+    }
+
+    staging := release + ".tmp"
+    archive := release + ".tar"
+    defer os.RemoveAll(staging)
+    defer removeFile(archive)
+    if err := os.RemoveAll(staging); err != nil {
+        return "", err
+    }
+    if err := os.Mkdir(staging, 0755); err != nil {
+        return "", err
+    }
+    if output, err := workspace.git(ctx, "archive", "--format=tar", "--output", archive, sha); err != nil {
+        return "", &WorkspaceError{ Step: StepRelease, Directory: workspace.Directory, Output: output, Err: err }
+    }
+    cmd := exec.CommandContext(ctx, "tar", "-xf", archive, "-C", staging)
+    if output, err := runLogged(ctx, cmd); err != nil {
+        return "", &WorkspaceError{ Step: StepRelease, Directory: staging, Output: output, Err: err }
+    }
+    if err := r.linkShared(staging); err != nil {
+        return "", &WorkspaceError{ Step: StepRelease, Directory: staging, Err: err }
+    }
+    if err := os.Rename(staging, release); err != nil {
+        return "", err
+    }
+    log.Info(fmt.Sprintf("created release %s", release))
+    return release, r.record(sha)
+}
+
+// function used to determine if release of commit SHA exists
+func (r *Releases) Exists(sha string) bool {
+    if len(sha) == 0 {
+        return false
+    }
+    info, err := os.Stat(r.Path(sha))
+    return err == nil && info.IsDir()
+}
+
+// function used to switch current release to release with given commit
+// SHA. the symlink is replaced atomically so that the current release
+// is always valid, and relative links are used so that applications can be moved
+func (r *Releases) Activate(sha string) error {
+    if _, err := os.Stat(r.Path(sha)); err != nil {
+        return UnknownReleaseError
+    }
+    link := filepath.Join(r.Directory, CurrentRelease)
+    staging := link + ".tmp"
+    if err := removeFile(staging); err != nil {
+        return err
+    }
+    if err := os.Symlink(filepath.Join(ReleasesDirectory, sha), staging); err != nil {
+        return err
+    }
+    log.Info(fmt.Sprintf("switching current release of directory %s to %s", r.Directory, sha))
+    return os.Rename(staging, link)
+}
+
+// function used to determine which release should be rolled back to.
+// the given commit SHA is used if set, else the release that was
+// created before the current release
+func (r *Releases) RollbackTarget(sha string) (string, error) {
+    history, err := r.History()
+    if err != nil {
+        return "", err
+    }
+    if len(sha) > 0 {
+        for _, release := range(history) {
+            if strings.HasPrefix(release, sha) {
+                return release, nil
+            }
+        }
+        return "", UnknownReleaseError
+    }
+    current, err := r.Current()
+    if err != nil {
+        return "", err
+    }
+    for i, release := range(history) {
+        if release == current {
+            if i == len(history) - 1 {
+                break
+            }
+            return history[i + 1], nil
+        }
+    }
+    return "", NoPreviousReleaseError
+}
+
+// function used to remove old releases. the newest releases are kept along
+// with the current release, which is never removed even after rollbacks.
+// the commit SHAs of the removed releases are returned
+func (r *Releases) Prune(keep int) ([]string, error) {
+    history, err := r.History()
+    if err != nil {
+        return nil, err
+    }
+    current, _ := r.Current()
+    kept, removed := []string{}, []string{}
+    for i, release := range(history) {
+        if i < keep || release == current {
+            kept = append(kept, release)
+            continue
+        }
+        log.Info(fmt.Sprintf("removing old release %s", r.Path(release)))
+        if err := os.RemoveAll(r.Path(release)); err != nil {
+            return removed, err
+        }
+        removed = append(removed, release)
+    }
+    return removed, r.writeHistory(kept)
+}
+
+// function used to list commit SHAs of existing releases, newest first
+func (r *Releases) History() ([]string, error) {
+    file, err := os.Open(filepath.Join(r.Directory, ReleasesDirectory, releaseHistoryFile))
+    if os.IsNotExist(err) {
+        return []string{}, nil
+    } else if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    history := []string{}
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        sha := strings.TrimSpace(scanner.Text())
+        if _, err := os.Stat(r.Path(sha)); len(sha) > 0 && err == nil {
+            history = append(history, sha)
+        }
+    }
+    return history, scanner.Err()
+}
+
+// function used to get the time that the last successfully deployed push was
+// triggered at. zero is returned if no push has been deployed since trigger
+// times were introduced
+func (r *Releases) DeployedTrigger() time.Time {
+    body, err := ioutil.ReadFile(filepath.Join(r.Directory, ReleasesDirectory, deployedTriggerFile))
+    if err != nil {
+        return time.Time{}
+    }
+    triggered, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(body)))
+    if err != nil {
+        log.Warn(fmt.Sprintf("unable to parse trigger time of last deployed push in directory %s: %v", r.Directory, err))
+        return time.Time{}
+    }
+    return triggered
+}
+
+// function used to record trigger time of successfully deployed push. trigger
+// times are only recorded if the push was triggered after the last deployed push
+func (r *Releases) RecordDeployedTrigger(triggered time.Time) error {
+    if triggered.IsZero() || !triggered.After(r.DeployedTrigger()) {
+        return nil
+    }
+    path := filepath.Join(r.Directory, ReleasesDirectory, deployedTriggerFile)
+    if err := ioutil.WriteFile(path + ".tmp", []byte(triggered.Format(time.RFC3339Nano) + "\n"), 0644); err != nil {
+        return err
+    }
+    return os.Rename(path + ".tmp", path)
+}
+
+// helper function used to record release as newest release in history
+func (r *Releases) record(sha string) error {
+    history, err := r.History()
+    if err != nil {
+        return err
+    }
+    updated := []string{ sha }
+    for _, release := range(history) {
+        if release != sha {
+            updated = append(updated, release)
+        }
+    }
+    return r.writeHistory(updated)
+}
+
+// helper function used to write history of releases
+func (r *Releases) writeHistory(history []string) error {
+    path := filepath.Join(r.Directory, ReleasesDirectory, releaseHistoryFile)
+    if err := ioutil.WriteFile(path + ".tmp", []byte(strings.Join(history, "\n") + "\n"), 0644); err != nil {
+        return err
+    }
+    return os.Rename(path + ".tmp", path)
+}
+
+// helper function used to link files of the shared directory into release.
+// files of the repo are replaced by shared files with the same name, and
+// relative links are used so that applications can be moved
+func (r *Releases) linkShared(release string) error {
+    shared := filepath.Join(r.Directory, SharedDirectory)
+    entries, err := ioutil.ReadDir(shared)
+    if os.IsNotExist(err) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+    for _, entry := range(entries) {
+        target := filepath.Join(release, entry.Name())
+        if err := os.RemoveAll(target); err != nil {
+            return err
+        }
+        if err := os.Symlink(filepath.Join("..", "..", SharedDirectory, entry.Name()), target); err != nil {
+            return err
+        }
+    }
+    return nil
+}
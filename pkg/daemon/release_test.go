@@ -0,0 +1,136 @@
+package daemon
+
+import (
+    "os"
+    "time"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+)
+
+// helper function used to create application directory with releases of
+// the given commit SHAs, created oldest first, and the given current release
+func testReleases(t *testing.T, shas []string, current string) *Releases {
+    directory, err := ioutil.TempDir("", "go-get-git-releases")
+    if err != nil {
+        t.Fatalf("unable to create temporary directory: %v", err)
+    }
+    t.Cleanup(func() { os.RemoveAll(directory) })
+    releases := NewReleases(directory)
+    for _, sha := range(shas) {
+        if err := os.MkdirAll(releases.Path(sha), 0755); err != nil {
+            t.Fatalf("unable to create release %s: %v", sha, err)
+        }
+        if err := releases.record(sha); err != nil {
+            t.Fatalf("unable to record release %s: %v", sha, err)
+        }
+    }
+    if len(current) > 0 {
+        if err := releases.Activate(current); err != nil {
+            t.Fatalf("unable to activate release %s: %v", current, err)
+        }
+    }
+    return releases
+}
+
+func TestReleasesRollbackTarget(t *testing.T) {
+    tests := []struct {
+        name    string
+        shas    []string
+        current string
+        sha     string
+        target  string
+        err     error
+    }{
+        { "release before rolled back release", []string{ "aaa111", "bbb222", "ccc333" }, "bbb222", "", "aaa111", nil },
+        { "requested release by prefix", []string{ "aaa111", "bbb222", "ccc333" }, "ccc333", "aaa", "aaa111", nil },
+        { "unknown requested release", []string{ "aaa111", "bbb222" }, "bbb222", "fff", "", UnknownReleaseError },
+        { "oldest release is current", []string{ "aaa111", "bbb222" }, "aaa111", "", "", NoPreviousReleaseError },
+        { "no current release", []string{ "aaa111", "bbb222" }, "", "", "", NoCurrentReleaseError },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            releases := testReleases(t, test.shas, test.current)
+            target, err := releases.RollbackTarget(test.sha)
+            if err != test.err || target != test.target {
+                t.Errorf("expected target %s with error %v, got %s with error %v", test.target, test.err, target, err)
+            }
+        })
+    }
+}
+
+func TestReleasesPrune(t *testing.T) {
+    tests := []struct {
+        name    string
+        shas    []string
+        current string
+        keep    int
+        removed []string
+        history []string
+    }{
+        { "current release is kept after rollback", []string{ "aaa111", "bbb222", "ccc333", "ddd444" }, "aaa111", 2, []string{ "bbb222" }, []string{ "ddd444", "ccc333", "aaa111" } },
+        { "only current release is kept", []string{ "aaa111", "bbb222", "ccc333" }, "bbb222", 0, []string{ "ccc333", "aaa111" }, []string{ "bbb222" } },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            releases := testReleases(t, test.shas, test.current)
+            removed, err := releases.Prune(test.keep)
+            if err != nil {
+                t.Fatalf("unable to prune releases: %v", err)
+            }
+            history, _ := releases.History()
+            if !equalStrings(removed, test.removed) || !equalStrings(history, test.history) {
+                t.Errorf("expected removed %v and history %v, got removed %v and history %v", test.removed, test.history, removed, history)
+            }
+            for _, sha := range(removed) {
+                if releases.Exists(sha) {
+                    t.Errorf("expected release %s to be removed", sha)
+                }
+            }
+        })
+    }
+}
+
+func TestReleasesActivate(t *testing.T) {
+    releases := testReleases(t, []string{ "aaa111", "bbb222" }, "aaa111")
+    if err := releases.Activate("fff999"); err != UnknownReleaseError {
+        t.Errorf("expected unknown release error, got %v", err)
+    }
+    if current, err := releases.Current(); err != nil || current != "aaa111" {
+        t.Errorf("expected current release to be kept, got %s with error %v", current, err)
+    }
+}
+
+func TestReleasesRecordDeployedTrigger(t *testing.T) {
+    releases := testReleases(t, []string{ "aaa111" }, "aaa111")
+    triggered := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+    for _, recorded := range([]time.Time{ triggered, triggered.Add(-time.Minute), time.Time{} }) {
+        if err := releases.RecordDeployedTrigger(recorded); err != nil {
+            t.Fatalf("unable to record trigger time: %v", err)
+        }
+    }
+    if deployed := releases.DeployedTrigger(); !deployed.Equal(triggered) {
+        t.Errorf("expected trigger time of last deployed push to be %s, got %s", triggered, deployed)
+    }
+}
+
+func TestReleasesMigrate(t *testing.T) {
+    releases := testReleases(t, nil, "")
+    // checkouts may contain a directory with the same name as the repo directory
+    for _, path := range([]string{ ".git", RepoDirectory }) {
+        if err := os.MkdirAll(filepath.Join(releases.Directory, path), 0755); err != nil {
+            t.Fatalf("unable to create checkout: %v", err)
+        }
+    }
+    if err := releases.Migrate(); err != nil {
+        t.Fatalf("unable to migrate checkout: %v", err)
+    }
+    for _, path := range([]string{ ".git", RepoDirectory }) {
+        if _, err := os.Stat(filepath.Join(releases.RepoDirectory(), path)); err != nil {
+            t.Errorf("expected %s to be moved into repo directory: %v", path, err)
+        }
+    }
+    if releases.CurrentDirectory() != releases.RepoDirectory() {
+        t.Errorf("expected migrated application to be deployed from repo directory, got %s", releases.CurrentDirectory())
+    }
+}
@@ -0,0 +1,240 @@
+package daemon
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "context"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// struct used to define a job run by the scheduler. jobs that carry a
+// push event can be coalesced with newer pushes of the same application.
+// the settle function is called with the result once the job has run,
+// and the discard function if the job is dropped without being run
+type Job struct {
+    Application string
+    Description string
+    Push        *events.GitPushEvent
+    Run         func(ctx context.Context) error
+    Settle      func(ctx context.Context, err error)
+    Discard     func()
+}
+
+// struct used to store the pending jobs of an application. applications
+// are only scheduled on a single worker at a time, so that jobs of the
+// same application never run concurrently
+type applicationQueue struct {
+    pending []Job
+    running bool
+}
+
+// struct used to run jobs on a pool of workers. jobs of different
+// applications are run in parallel, while jobs of the same application
+// are run strictly in the order that they were submitted
+type Scheduler struct {
+    workers      int
+    mutex        sync.Mutex
+    cond         *sync.Cond
+    applications map[string]*applicationQueue
+    ready        []string
+    latest       map[string]time.Time
+    stopped      bool
+    cancel       context.CancelFunc
+    wg           sync.WaitGroup
+}
+
+// function used to create new scheduler with given number of workers
+func NewScheduler(workers int) *Scheduler {
+    if workers < 1 {
+        workers = 1
+    }
+    scheduler := &Scheduler{ workers: workers, applications: map[string]*applicationQueue{}, latest: map[string]time.Time{} }
+    scheduler.cond = sync.NewCond(&scheduler.mutex)
+    return scheduler
+}
+
+// function used to start workers of scheduler. jobs are run with a
+// context that is cancelled if they do not finish during shutdown
+func (s *Scheduler) Start(ctx context.Context) {
+    log.Info(fmt.Sprintf("starting scheduler with %d worker(s)", s.workers))
+    ctx, s.cancel = context.WithCancel(ctx)
+    for i := 0; i < s.workers; i++ {
+        s.wg.Add(1)
+        go s.worker(ctx, i)
+    }
+}
+
+// function used to shut scheduler down. pending jobs are discarded and
+// running jobs are given the grace period to finish, after which their
+// context is cancelled. the function blocks until all workers have exited
+// and returns false if running jobs had to be cancelled
+func (s *Scheduler) Shutdown(grace time.Duration) bool {
+    s.mutex.Lock()
+    s.stopped = true
+    var discarded []Job
+    for _, queue := range(s.applications) {
+        discarded = append(discarded, queue.pending...)
+        queue.pending = nil
+    }
+    s.ready = nil
+    s.cond.Broadcast()
+    s.mutex.Unlock()
+    for _, job := range(discarded) {
+        s.discard(job)
+    }
+
+    done := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return true
+    case <-time.After(grace):
+        log.Warn(fmt.Sprintf("running jobs did not finish within %s. cancelling jobs", grace))
+    }
+    if s.cancel != nil {
+        s.cancel()
+    }
+    <-done
+    return false
+}
+
+// function used to submit new job to scheduler. pushes are coalesced
+// with the last pending job of the application if it is also a push,
+// so that only the most recently triggered push is built once the
+// application is free. pushes that were triggered before the pending
+// push, such as retried pushes, are superseded by the pending push.
+// superseded jobs are returned so that they can be reported as skipped
+func (s *Scheduler) Submit(job Job) *Job {
+    s.mutex.Lock()
+    if s.stopped {
+        s.mutex.Unlock()
+        s.discard(job)
+        return nil
+    }
+    defer s.mutex.Unlock()
+
+    if job.Push != nil && job.Push.TriggeredAt.After(s.latest[job.Application]) {
+        s.latest[job.Application] = job.Push.TriggeredAt
+    }
+    queue, ok := s.applications[job.Application]
+    if !ok {
+        queue = &applicationQueue{}
+        s.applications[job.Application] = queue
+    }
+    var superseded *Job
+    if last := len(queue.pending) - 1; last >= 0 && job.Push != nil && queue.pending[last].Push != nil {
+        previous := queue.pending[last]
+        if triggeredBefore(*job.Push, *previous.Push) {
+            log.Info(fmt.Sprintf("coalescing job %s with pending job %s triggered after it", job.Description, previous.Description))
+            return &job
+        }
+        superseded = &previous
+        log.Info(fmt.Sprintf("coalescing pending job %s with newer job %s", previous.Description, job.Description))
+        queue.pending[last] = job
+    } else {
+        queue.pending = append(queue.pending, job)
+    }
+    // applications are only added to the ready list when they are idle,
+    // since running applications are scheduled again once their job finishes.
+    // applications with coalesced jobs are already on the ready list
+    if superseded == nil && !queue.running && len(queue.pending) == 1 {
+        s.ready = append(s.ready, job.Application)
+        s.cond.Signal()
+    }
+    return superseded
+}
+
+// function used to determine if a push has been superseded by a push
+// of the same application that was submitted after being triggered later
+func (s *Scheduler) Superseded(application string, push events.GitPushEvent) bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return triggeredBefore(push, events.GitPushEvent{ TriggeredAt: s.latest[application] })
+}
+
+// function used to run jobs of ready applications until scheduler is stopped
+func (s *Scheduler) worker(ctx context.Context, id int) {
+    defer s.wg.Done()
+    for {
+        application, job, ok := s.next()
+        if !ok {
+            log.Debug(fmt.Sprintf("stopping scheduler worker %d", id))
+            return
+        }
+        log.Info(fmt.Sprintf("worker %d running job %s", id, job.Description))
+        err := job.Run(ctx)
+        if err != nil {
+            log.Error(fmt.Errorf("job %s failed: %v", job.Description, err))
+        }
+        if job.Settle != nil {
+            job.Settle(ctx, err)
+        }
+        s.finish(application)
+    }
+}
+
+// helper function used to wait for next application with pending jobs.
+// the application is marked as running until the job has finished
+func (s *Scheduler) next() (string, Job, bool) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    for len(s.ready) == 0 && !s.stopped {
+        s.cond.Wait()
+    }
+    if s.stopped {
+        return "", Job{}, false
+    }
+    application := s.ready[0]
+    s.ready = s.ready[1:]
+    queue := s.applications[application]
+    job := queue.pending[0]
+    queue.pending = queue.pending[1:]
+    queue.running = true
+    return application, job, true
+}
+
+// helper function used to release application once job has finished.
+// applications with remaining jobs are added back to the ready list
+func (s *Scheduler) finish(application string) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    queue := s.applications[application]
+    queue.running = false
+    if len(queue.pending) == 0 {
+        delete(s.applications, application)
+        return
+    }
+    s.ready = append(s.ready, application)
+    s.cond.Signal()
+}
+
+// helper function used to discard job that will not be run
+func (s *Scheduler) discard(job Job) {
+    log.Warn(fmt.Sprintf("scheduler is stopped. discarding job %s", job.Description))
+    if job.Discard != nil {
+        job.Discard()
+    }
+}
+
+// helper function used to determine if push was triggered before another
+// push. pushes sent by previous versions of the API carry no trigger time
+// and are never considered to be triggered before other pushes
+func triggeredBefore(push, other events.GitPushEvent) bool {
+    return !push.TriggeredAt.IsZero() && push.TriggeredAt.Before(other.TriggeredAt)
+}
+
+// helper function used to determine which application an event belongs
+// to. applications are identified by entry ID so that moved applications
+// are serialized with their previous directory, and by directory otherwise
+func applicationKey(entryId uuid.UUID, directory string) string {
+    if entryId != uuid.Nil {
+        return entryId.String()
+    }
+    return directory
+}
@@ -0,0 +1,166 @@
+package daemon
+
+import (
+    "sync"
+    "time"
+    "context"
+    "testing"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+)
+
+// helper function used to create job of application for scheduler tests
+func testJob(application, description string) Job {
+    return Job{ Application: application, Description: description, Run: func(ctx context.Context) error { return nil } }
+}
+
+// helper function used to create push job triggered the given number of minutes after a fixed time
+func testPush(application, description string, minutes int) Job {
+    job := testJob(application, description)
+    job.Push = &events.GitPushEvent{ ApplicationDirectory: application, TriggeredAt: time.Date(2020, 1, 1, 0, minutes, 0, 0, time.UTC) }
+    return job
+}
+
+func TestSchedulerSubmitCoalescing(t *testing.T) {
+    tests := []struct {
+        name       string
+        jobs       []Job
+        pending    []string
+        superseded []string
+    }{
+        {
+            "pushes are only coalesced with the last pending job",
+            []Job{ testPush("app", "push-1", 1), testJob("app", "move"), testPush("app", "push-2", 2), testPush("app", "push-3", 3) },
+            []string{ "push-1", "move", "push-3" },
+            []string{ "push-2" },
+        },
+        {
+            "retried push is superseded by pending push triggered after it",
+            []Job{ testPush("app", "push-2", 2), testPush("app", "push-1", 1) },
+            []string{ "push-2" },
+            []string{ "push-1" },
+        },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            scheduler := NewScheduler(1)
+            superseded := []string{}
+            for _, job := range(test.jobs) {
+                if previous := scheduler.Submit(job); previous != nil {
+                    superseded = append(superseded, previous.Description)
+                }
+            }
+            pending := []string{}
+            for _, job := range(scheduler.applications["app"].pending) {
+                pending = append(pending, job.Description)
+            }
+            if !equalStrings(pending, test.pending) || !equalStrings(superseded, test.superseded) || len(scheduler.ready) != 1 {
+                t.Errorf("expected pending %v and superseded %v, got pending %v and superseded %v with ready applications %v",
+                    test.pending, test.superseded, pending, superseded, scheduler.ready)
+            }
+        })
+    }
+}
+
+func TestSchedulerSuperseded(t *testing.T) {
+    scheduler := NewScheduler(1)
+    scheduler.Submit(testPush("app", "push-2", 2))
+    if !scheduler.Superseded("app", *testPush("app", "push-1", 1).Push) {
+        t.Errorf("expected push triggered before submitted push to be superseded")
+    }
+    if scheduler.Superseded("app", *testPush("app", "push-2", 2).Push) || scheduler.Superseded("other", *testPush("other", "push-1", 1).Push) {
+        t.Errorf("expected push to only be superseded by later pushes of the same application")
+    }
+    if scheduler.Superseded("app", events.GitPushEvent{ ApplicationDirectory: "app" }) {
+        t.Errorf("expected push without trigger time to never be superseded")
+    }
+}
+
+func TestSchedulerRunsApplicationJobsInOrder(t *testing.T) {
+    scheduler := NewScheduler(4)
+    var (mutex sync.Mutex; wg sync.WaitGroup)
+    order := map[string][]string{}
+    running := map[string]bool{}
+    for _, application := range([]string{ "app-1", "app-2" }) {
+        for _, description := range([]string{ "first", "second", "third" }) {
+            application, description := application, description
+            wg.Add(1)
+            scheduler.Submit(Job{
+                Application: application,
+                Description: description,
+                Run: func(ctx context.Context) error {
+                    mutex.Lock()
+                    if running[application] {
+                        t.Errorf("jobs of application %s ran concurrently", application)
+                    }
+                    running[application] = true
+                    mutex.Unlock()
+                    time.Sleep(10 * time.Millisecond)
+                    mutex.Lock()
+                    running[application] = false
+                    order[application] = append(order[application], description)
+                    mutex.Unlock()
+                    return nil
+                },
+                Settle: func(ctx context.Context, err error) {
+                    wg.Done()
+                },
+            })
+        }
+    }
+    scheduler.Start(context.Background())
+    wg.Wait()
+    scheduler.Shutdown(time.Second)
+    for application, descriptions := range(order) {
+        if !equalStrings(descriptions, []string{ "first", "second", "third" }) {
+            t.Errorf("expected jobs of application %s to run in order, got %v", application, descriptions)
+        }
+    }
+}
+
+func TestSchedulerShutdown(t *testing.T) {
+    scheduler := NewScheduler(1)
+    started, discarded := make(chan struct{}), 0
+    var cancelled error
+    scheduler.Submit(Job{
+        Application: "app",
+        Description: "running",
+        Run: func(ctx context.Context) error {
+            close(started)
+            <-ctx.Done()
+            return ctx.Err()
+        },
+        Settle: func(ctx context.Context, err error) {
+            cancelled = err
+        },
+    })
+    pending := testJob("app", "pending")
+    pending.Discard = func() { discarded++ }
+    scheduler.Submit(pending)
+    scheduler.Start(context.Background())
+    <-started
+
+    if scheduler.Shutdown(10 * time.Millisecond) {
+        t.Errorf("expected running job to be cancelled")
+    }
+    if cancelled != context.Canceled || discarded != 1 {
+        t.Errorf("expected running job to be cancelled and pending job to be discarded, got error %v and %d discarded job(s)", cancelled, discarded)
+    }
+    late := testJob("app", "late")
+    late.Discard = func() { discarded++ }
+    if scheduler.Submit(late) != nil || discarded != 2 {
+        t.Errorf("expected jobs submitted after shutdown to be discarded")
+    }
+}
+
+// helper function used to compare string slices
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range(a) {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,211 @@
+package daemon
+
+import (
+    "fmt"
+    "time"
+    "context"
+    "encoding/json"
+    "github.com/google/uuid"
+    bolt "go.etcd.io/bbolt"
+    log "github.com/sirupsen/logrus"
+)
+
+// define states of events recorded in the processed events store
+const (
+    EventStarted = "started"
+    EventCompleted = "completed"
+)
+
+// define bucket that processed events are stored in
+var processedEventsBucket = []byte("processed_events")
+
+type eventProgressKey struct{}
+
+// struct used to store processing state of an event. the last completed
+// step is recorded so that redelivered events can resume from it
+type EventRecord struct {
+    EventType string    `json:"event_type"`
+    State     string    `json:"state"`
+    Step      string    `json:"step,omitempty"`
+    Release   string    `json:"release,omitempty"`
+    Attempts  int       `json:"attempts"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// struct used to store processed events in an embedded key-value file.
+// events are keyed by event ID, and records are pruned once they have
+// not been updated for longer than the retention period
+type EventStore struct {
+    db *bolt.DB
+}
+
+// function used to open processed events store at given path. the
+// file is created if it does not exist
+func OpenEventStore(path string) (*EventStore, error) {
+    db, err := bolt.Open(path, 0600, &bolt.Options{ Timeout: 10 * time.Second })
+    if err != nil {
+        return nil, fmt.Errorf("unable to open processed events store %s: %v", path, err)
+    }
+    err = db.Update(func(tx *bolt.Tx) error {
+        _, err := tx.CreateBucketIfNotExists(processedEventsBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, fmt.Errorf("unable to create processed events bucket: %v", err)
+    }
+    return &EventStore{ db: db }, nil
+}
+
+// function used to close processed events store
+func (s *EventStore) Close() error {
+    return s.db.Close()
+}
+
+// function used to mark event as started. the previous record of the
+// event is returned so that duplicates can be skipped or resumed
+func (s *EventStore) Start(eventId uuid.UUID, eventType string) (*EventProgress, error) {
+    progress := &EventProgress{ store: s, eventId: eventId }
+    err := s.update(eventId, func(record *EventRecord, exists bool) {
+        progress.previous = *record
+        if !exists {
+            record.EventType = eventType
+        }
+        if record.State != EventCompleted {
+            record.State = EventStarted
+            record.Attempts++
+        }
+    })
+    return progress, err
+}
+
+// function used to record that a step of an event has been completed
+func (s *EventStore) Checkpoint(eventId uuid.UUID, step, release string) error {
+    return s.update(eventId, func(record *EventRecord, exists bool) {
+        record.Step, record.Release = step, release
+    })
+}
+
+// function used to mark event as completed. completed events are
+// skipped if they are delivered again
+func (s *EventStore) Complete(eventId uuid.UUID, eventType string) error {
+    return s.update(eventId, func(record *EventRecord, exists bool) {
+        if !exists {
+            record.EventType = eventType
+        }
+        record.State = EventCompleted
+    })
+}
+
+// function used to remove records that have not been updated within the
+// retention period. the number of removed records is returned
+func (s *EventStore) Prune(retention time.Duration) (int, error) {
+    cutoff := time.Now().Add(-retention)
+    removed := 0
+    err := s.db.Update(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(processedEventsBucket)
+        cursor := bucket.Cursor()
+        for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+            var record EventRecord
+            if err := json.Unmarshal(value, &record); err == nil && record.UpdatedAt.After(cutoff) {
+                continue
+            }
+            if err := cursor.Delete(); err != nil {
+                return err
+            }
+            removed++
+        }
+        return nil
+    })
+    return removed, err
+}
+
+// function used to prune processed events store periodically until
+// the context is cancelled
+func (s *EventStore) RunPruner(ctx context.Context, retention, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        removed, err := s.Prune(retention)
+        if err != nil {
+            log.Error(fmt.Errorf("unable to prune processed events store: %v", err))
+        } else if removed > 0 {
+            log.Info(fmt.Sprintf("pruned %d processed event(s) older than %s", removed, retention))
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// helper function used to read, modify and write record of event in a
+// single transaction. records that do not exist are created
+func (s *EventStore) update(eventId uuid.UUID, modify func(record *EventRecord, exists bool)) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(processedEventsBucket)
+        key := []byte(eventId.String())
+        var record EventRecord
+        value := bucket.Get(key)
+        exists := value != nil
+        if exists {
+            if err := json.Unmarshal(value, &record); err != nil {
+                log.Warn(fmt.Sprintf("discarding invalid record of event %s: %v", eventId, err))
+                record, exists = EventRecord{}, false
+            }
+        }
+        modify(&record, exists)
+        record.UpdatedAt = time.Now().UTC()
+        body, err := json.Marshal(&record)
+        if err != nil {
+            return err
+        }
+        return bucket.Put(key, body)
+    })
+}
+
+// struct used to track progress of event while it is processed. the
+// record of the previous attempt is used to skip completed steps
+type EventProgress struct {
+    store    *EventStore
+    eventId  uuid.UUID
+    previous EventRecord
+}
+
+// function used to determine if event has already been processed
+func (p *EventProgress) Completed() bool {
+    return p != nil && p.previous.State == EventCompleted
+}
+
+// function used to retrieve step and release completed by the previous
+// attempt of the event. empty strings are returned if there was none
+func (p *EventProgress) Resume() (string, string) {
+    if p == nil {
+        return "", ""
+    }
+    return p.previous.Step, p.previous.Release
+}
+
+// function used to record completed step of event. failures are only
+// logged, since events are processed in full if progress is not recorded
+func (p *EventProgress) Checkpoint(step, release string) {
+    if p == nil {
+        return
+    }
+    if err := p.store.Checkpoint(p.eventId, step, release); err != nil {
+        log.Warn(fmt.Sprintf("unable to record step %s of event %s: %v", step, p.eventId, err))
+    }
+}
+
+// function used to attach event progress to context
+func withEventProgress(ctx context.Context, progress *EventProgress) context.Context {
+    return context.WithValue(ctx, eventProgressKey{}, progress)
+}
+
+// function used to retrieve event progress from context. nil is returned
+// if the context has no event progress
+func eventProgressFromContext(ctx context.Context) *EventProgress {
+    progress, _ := ctx.Value(eventProgressKey{}).(*EventProgress)
+    return progress
+}
@@ -0,0 +1,159 @@
+package daemon
+
+import (
+    "os"
+    "time"
+    "context"
+    "testing"
+    "io/ioutil"
+    "path/filepath"
+    "encoding/json"
+    "github.com/google/uuid"
+    bolt "go.etcd.io/bbolt"
+)
+
+// helper function used to open processed events store in temporary directory
+func testEventStore(t *testing.T) *EventStore {
+    directory, err := ioutil.TempDir("", "go-get-git-events")
+    if err != nil {
+        t.Fatalf("unable to create temporary directory: %v", err)
+    }
+    store, err := OpenEventStore(filepath.Join(directory, "events.db"))
+    if err != nil {
+        t.Fatalf("unable to open processed events store: %v", err)
+    }
+    t.Cleanup(func() {
+        store.Close()
+        os.RemoveAll(directory)
+    })
+    return store
+}
+
+// helper function used to read record of event from store
+func testEventRecord(t *testing.T, store *EventStore, eventId uuid.UUID) (EventRecord, bool) {
+    var (record EventRecord; exists bool)
+    err := store.db.View(func(tx *bolt.Tx) error {
+        value := tx.Bucket(processedEventsBucket).Get([]byte(eventId.String()))
+        if value == nil {
+            return nil
+        }
+        exists = true
+        return json.Unmarshal(value, &record)
+    })
+    if err != nil {
+        t.Fatalf("unable to read record of event %s: %v", eventId, err)
+    }
+    return record, exists
+}
+
+func TestEventStoreStart(t *testing.T) {
+    tests := []struct {
+        name      string
+        prepare   func(store *EventStore, eventId uuid.UUID)
+        completed bool
+        step      string
+        release   string
+        attempts  int
+        state     string
+    }{
+        {
+            "redelivered event with checkpoint",
+            func(store *EventStore, eventId uuid.UUID) {
+                progress, _ := store.Start(eventId, "GitPushEvent")
+                progress.Checkpoint(StepRelease, "abc123")
+            },
+            false, StepRelease, "abc123", 2, EventStarted,
+        },
+        {
+            "completed event",
+            func(store *EventStore, eventId uuid.UUID) {
+                store.Start(eventId, "GitPushEvent")
+                store.Complete(eventId, "GitPushEvent")
+            },
+            true, "", "", 1, EventCompleted,
+        },
+        {
+            "event completed without being started",
+            func(store *EventStore, eventId uuid.UUID) {
+                store.Complete(eventId, "GitPushEvent")
+            },
+            true, "", "", 0, EventCompleted,
+        },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            store, eventId := testEventStore(t), uuid.New()
+            test.prepare(store, eventId)
+            progress, err := store.Start(eventId, "GitPushEvent")
+            if err != nil {
+                t.Fatalf("unable to start event: %v", err)
+            }
+            step, release := progress.Resume()
+            if progress.Completed() != test.completed || step != test.step || release != test.release {
+                t.Errorf("expected completed %t resuming from step '%s' with release '%s', got completed %t resuming from step '%s' with release '%s'",
+                    test.completed, test.step, test.release, progress.Completed(), step, release)
+            }
+            record, _ := testEventRecord(t, store, eventId)
+            if record.State != test.state || record.Attempts != test.attempts || record.EventType != "GitPushEvent" {
+                t.Errorf("expected %s record with %d attempt(s), got %+v", test.state, test.attempts, record)
+            }
+        })
+    }
+}
+
+func TestEventStorePrune(t *testing.T) {
+    store := testEventStore(t)
+    current, expired, invalid := uuid.New(), uuid.New(), uuid.New()
+    store.Start(current, "GitPushEvent")
+    store.Start(expired, "GitPushEvent")
+    err := store.db.Update(func(tx *bolt.Tx) error {
+        bucket := tx.Bucket(processedEventsBucket)
+        body, _ := json.Marshal(&EventRecord{ EventType: "GitPushEvent", State: EventCompleted, UpdatedAt: time.Now().Add(-2 * time.Hour) })
+        if err := bucket.Put([]byte(expired.String()), body); err != nil {
+            return err
+        }
+        return bucket.Put([]byte(invalid.String()), []byte("invalid"))
+    })
+    if err != nil {
+        t.Fatalf("unable to prepare records: %v", err)
+    }
+
+    removed, err := store.Prune(time.Hour)
+    if err != nil || removed != 2 {
+        t.Fatalf("expected 2 records to be pruned, got %d with error %v", removed, err)
+    }
+    tests := []struct {
+        name    string
+        eventId uuid.UUID
+        exists  bool
+    }{
+        { "record within retention", current, true },
+        { "expired record", expired, false },
+        { "invalid record", invalid, false },
+    }
+    for _, test := range(tests) {
+        t.Run(test.name, func(t *testing.T) {
+            if _, exists := testEventRecord(t, store, test.eventId); exists != test.exists {
+                t.Errorf("expected record to exist %t, got %t", test.exists, exists)
+            }
+        })
+    }
+}
+
+func TestEventProgressWithoutStore(t *testing.T) {
+    progress := eventProgressFromContext(context.Background())
+    if progress != nil || progress.Completed() {
+        t.Fatalf("expected no event progress")
+    }
+    // progress is not recorded if events are processed without a store
+    progress.Checkpoint(StepRelease, "abc123")
+    if step, release := progress.Resume(); step != "" || release != "" {
+        t.Errorf("expected no step to resume from, got step '%s' with release '%s'", step, release)
+    }
+
+    store := testEventStore(t)
+    started, _ := store.Start(uuid.New(), "GitPushEvent")
+    if eventProgressFromContext(withEventProgress(context.Background(), started)) != started {
+        t.Errorf("expected event progress to be retrieved from context")
+    }
+}
@@ -0,0 +1,303 @@
+package daemon
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "bytes"
+    "bufio"
+    "context"
+    "strconv"
+    "strings"
+    "os/exec"
+    "encoding/json"
+    "github.com/PSauerborn/go-get-git/pkg/events"
+    "github.com/google/uuid"
+    log "github.com/sirupsen/logrus"
+)
+
+// define labels added to containers managed by go-get-git
+const (
+    LabelManaged = "go-get-git.managed"
+    LabelEntryId = "go-get-git.entry-id"
+    LabelRelease = "go-get-git.release"
+)
+
+// define initiators of container restarts
+const (
+    InitiatorDaemon = "daemon"
+    InitiatorDocker = "docker"
+)
+
+// define time after a container is stopped or killed in which the
+// container exiting is treated as intentional rather than as a crash
+var intentionalStopWindow = time.Minute
+
+// function used to generate labels of containers managed by go-get-git.
+// containers are only labelled if the entry that they belong to is known
+func managedLabels(entryId uuid.UUID, release string) map[string]string {
+    if entryId == uuid.Nil {
+        return nil
+    }
+    labels := map[string]string{ LabelManaged: "true", LabelEntryId: entryId.String() }
+    if len(release) > 0 {
+        labels[LabelRelease] = release
+    }
+    return labels
+}
+
+// struct used to parse events of the docker event stream
+type dockerEvent struct {
+    Action string `json:"Action"`
+    Id     string `json:"id"`
+    Actor  struct {
+        Attributes map[string]string `json:"Attributes"`
+    } `json:"Actor"`
+}
+
+// struct used to track crashes and restarts of a container. crashes that
+// are left to the docker restart policy are recorded along with their exit
+// code, so that the container starting again can be reported as a restart
+type containerState struct {
+    attempts  int
+    lastCrash time.Time
+    stoppedAt time.Time
+    restartPending bool
+    dockerRestartPending bool
+    exitCode  int
+}
+
+// struct used to follow docker events of containers managed by go-get-git.
+// crashed containers are restarted with exponential backoff unless docker
+// restarts them itself, and restarts are given up once the maximum number
+// of attempts is reached. attempts are reset once a container has not
+// crashed for the reset window
+type ContainerWatcher struct {
+    mutex      sync.Mutex
+    containers map[string]*containerState
+}
+
+// function used to create new container watcher
+func NewContainerWatcher() *ContainerWatcher {
+    return &ContainerWatcher{ containers: map[string]*containerState{} }
+}
+
+// function used to follow docker events until the context is cancelled.
+// the event stream is reconnected with backoff if it is closed
+func (w *ContainerWatcher) Run(ctx context.Context) {
+    log.Info("starting docker event watcher for managed containers")
+    backoff := time.Second
+    for {
+        started := time.Now()
+        err := w.follow(ctx)
+        if ctx.Err() != nil {
+            log.Info("stopping docker event watcher")
+            return
+        }
+        if time.Since(started) > time.Minute {
+            backoff = time.Second
+        }
+        log.Error(fmt.Errorf("docker event stream failed: %v. reconnecting in %s", err, backoff))
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > time.Minute {
+            backoff = time.Minute
+        }
+    }
+}
+
+// helper function used to follow docker event stream
+func (w *ContainerWatcher) follow(ctx context.Context) error {
+    cmd := exec.CommandContext(ctx, "docker", "events", "--format", "{{json .}}",
+        "--filter", "type=container",
+        "--filter", fmt.Sprintf("label=%s=true", LabelManaged),
+        "--filter", "event=die",
+        "--filter", "event=kill",
+        "--filter", "event=stop",
+        "--filter", "event=restart",
+        "--filter", "event=start",
+        "--filter", "event=destroy")
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return err
+    }
+    if err := cmd.Start(); err != nil {
+        return err
+    }
+    scanner := bufio.NewScanner(stdout)
+    for scanner.Scan() {
+        var event dockerEvent
+        if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+            log.Warn(fmt.Sprintf("unable to parse docker event %s: %v", scanner.Text(), err))
+            continue
+        }
+        w.handle(ctx, event)
+    }
+    err = cmd.Wait()
+    return fmt.Errorf("event stream closed: %v: %s", err, strings.TrimSpace(stderr.String()))
+}
+
+// helper function used to handle docker event of managed container
+func (w *ContainerWatcher) handle(ctx context.Context, event dockerEvent) {
+    attributes := event.Actor.Attributes
+    entryId, err := uuid.Parse(attributes[LabelEntryId])
+    if err != nil {
+        log.Debug(fmt.Sprintf("ignoring docker event of container %s without entry ID", event.Id))
+        return
+    }
+    log.Debug(fmt.Sprintf("received docker event %s for container %s", event.Action, event.Id))
+
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    state, ok := w.containers[event.Id]
+    if !ok {
+        state = &containerState{}
+        w.containers[event.Id] = state
+    }
+    switch event.Action {
+    case "kill", "stop":
+        state.stoppedAt = time.Now()
+    case "destroy":
+        delete(w.containers, event.Id)
+    case "restart":
+        w.publish(entryId, "ContainerRestartEvent", events.ContainerRestartEvent{
+            EntryId: entryId,
+            ContainerId: event.Id,
+            ContainerName: attributes["name"],
+            Initiator: InitiatorDocker,
+        })
+    case "start":
+        // docker only emits restart events for explicit restarts, while
+        // containers restarted by their restart policy die and start again
+        if !state.dockerRestartPending {
+            return
+        }
+        state.dockerRestartPending = false
+        log.Info(fmt.Sprintf("container %s was restarted by docker after crashing", attributes["name"]))
+        w.publish(entryId, "ContainerRestartEvent", events.ContainerRestartEvent{
+            EntryId: entryId,
+            ContainerId: event.Id,
+            ContainerName: attributes["name"],
+            ExitCode: state.exitCode,
+            Attempt: state.attempts,
+            Initiator: InitiatorDocker,
+        })
+    case "die":
+        exitCode, _ := strconv.Atoi(attributes["exitCode"])
+        // containers that exit cleanly or were stopped intentionally have not crashed
+        if exitCode == 0 || time.Since(state.stoppedAt) < intentionalStopWindow {
+            log.Debug(fmt.Sprintf("container %s exited with code %d after being stopped", event.Id, exitCode))
+            return
+        }
+        w.handleCrash(ctx, entryId, event, state, exitCode)
+    }
+}
+
+// helper function used to handle crashed container. Note that containers
+// with a docker restart policy are restarted by docker, and are only reported.
+// the state mutex must be held when called
+func (w *ContainerWatcher) handleCrash(ctx context.Context, entryId uuid.UUID, event dockerEvent, state *containerState, exitCode int) {
+    name := event.Actor.Attributes["name"]
+    log.Warn(fmt.Sprintf("container %s of entry %s crashed with exit code %d", name, entryId, exitCode))
+    if time.Since(state.lastCrash) > time.Duration(RestartResetMinutes) * time.Minute {
+        state.attempts = 0
+    }
+    state.lastCrash = time.Now()
+
+    exhausted := false
+    if policy := restartPolicy(ctx, event.Id); policy != "" && policy != "no" {
+        log.Info(fmt.Sprintf("container %s has restart policy %s. leaving restart to docker", name, policy))
+        state.attempts++
+        state.dockerRestartPending, state.exitCode = true, exitCode
+    } else if RestartMaxAttempts > 0 {
+        if state.attempts >= RestartMaxAttempts {
+            log.Error(fmt.Errorf("giving up restarting container %s after %d attempts", name, state.attempts))
+            exhausted = true
+        } else if !state.restartPending {
+            state.attempts++
+            state.restartPending = true
+            go w.restart(ctx, entryId, event.Id, name, exitCode, state.attempts)
+        }
+    }
+    w.publish(entryId, "ContainerCrashedEvent", events.ContainerCrashedEvent{
+        EntryId: entryId,
+        ContainerId: event.Id,
+        ContainerName: name,
+        Release: event.Actor.Attributes[LabelRelease],
+        ExitCode: exitCode,
+        RestartsExhausted: exhausted,
+    })
+}
+
+// helper function used to restart container after backoff. restarts are
+// skipped if the container has been removed in the meantime, which is the
+// case if the application has been redeployed
+func (w *ContainerWatcher) restart(ctx context.Context, entryId uuid.UUID, id, name string, exitCode, attempt int) {
+    delay := restartBackoff(attempt)
+    log.Info(fmt.Sprintf("restarting container %s in %s (attempt %d of %d)", name, delay, attempt, RestartMaxAttempts))
+    select {
+    case <-ctx.Done():
+        return
+    case <-time.After(delay):
+    }
+
+    w.mutex.Lock()
+    state, ok := w.containers[id]
+    if ok {
+        state.restartPending = false
+    }
+    w.mutex.Unlock()
+    if !ok {
+        log.Info(fmt.Sprintf("container %s has been removed. skipping restart", name))
+        return
+    }
+
+    restart := events.ContainerRestartEvent{
+        EntryId: entryId,
+        ContainerId: id,
+        ContainerName: name,
+        ExitCode: exitCode,
+        Attempt: attempt,
+        Initiator: InitiatorDaemon,
+    }
+    if output, err := exec.CommandContext(ctx, "docker", "start", id).CombinedOutput(); err != nil {
+        log.Error(fmt.Errorf("unable to restart container %s: %v: %s", name, err, strings.TrimSpace(string(output))))
+        restart.Error = fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    w.publish(entryId, "ContainerRestartEvent", restart)
+}
+
+// helper function used to publish container event over event exchange
+func (w *ContainerWatcher) publish(entryId uuid.UUID, eventType string, payload interface{}) {
+    if err := sendRabbitPayload(events.New(eventType, ApplicationId, entryId, payload)); err != nil {
+        log.Error(fmt.Errorf("unable to publish %s for entry %s: %v", eventType, entryId, err))
+    }
+}
+
+// helper function used to retrieve docker restart policy of container
+func restartPolicy(ctx context.Context, id string) string {
+    output, err := commandOutputLines(ctx, "", nil, "docker", "inspect", "--type", "container", "--format", "{{.HostConfig.RestartPolicy.Name}}", id)
+    if err != nil || len(output) == 0 {
+        return ""
+    }
+    return output[0]
+}
+
+// helper function used to determine delay before restart attempt. delays
+// are doubled with each attempt up to the maximum backoff
+func restartBackoff(attempt int) time.Duration {
+    delay := time.Duration(RestartBackoffSeconds) * time.Second
+    limit := time.Duration(RestartMaxBackoffSeconds) * time.Second
+    for i := 1; i < attempt && delay < limit; i++ {
+        delay *= 2
+    }
+    if delay > limit {
+        return limit
+    }
+    return delay
+}
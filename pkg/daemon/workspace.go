@@ -0,0 +1,213 @@
+package daemon
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "errors"
+    "context"
+    "strings"
+    "io/ioutil"
+    "path/filepath"
+    log "github.com/sirupsen/logrus"
+)
+
+// define set of steps that workspace operations can fail at
+const (
+    StepCredentials = "credentials"
+    StepClone = "clone"
+    StepFetch = "fetch"
+    StepResolve = "resolve"
+    StepCheckout = "checkout"
+    StepClean = "clean"
+)
+
+var ExistingDirectoryError = errors.New("directory exists and is not a git checkout")
+
+// struct used to report errors raised by git commands run in a
+// workspace, along with the step that failed and the command output
+type WorkspaceError struct {
+    Step      string
+    Directory string
+    Output    string
+    Err       error
+}
+
+func (e *WorkspaceError) Error() string {
+    if len(e.Output) > 0 {
+        return fmt.Sprintf("workspace step '%s' failed in directory %s: %v: %s", e.Step, e.Directory, e.Err, e.Output)
+    }
+    return fmt.Sprintf("workspace step '%s' failed in directory %s: %v", e.Step, e.Directory, e.Err)
+}
+
+func (e *WorkspaceError) Unwrap() error {
+    return e.Err
+}
+
+// struct used to manage the git checkout of an application. The
+// repo is cloned on first use, and subsequent syncs fetch from the
+// remote and force the checkout to the target revision
+type Workspace struct {
+    RepoUrl     string
+    Directory   string
+    Credentials *GitCredentials
+    env         []string
+}
+
+// function used to create new workspace for a given repo. repos are
+// cloned anonymously if no credentials are given
+func NewWorkspace(repoUrl, directory string, credentials *GitCredentials) *Workspace {
+    return &Workspace{ RepoUrl: repoUrl, Directory: directory, Credentials: credentials }
+}
+
+// function used to sync workspace with remote and checkout target
+// revision. the default branch of the remote is checked out if no
+// target is given. Checkouts that are corrupted or cannot be updated
+// are removed and cloned again. the SHA of the checked out commit is returned
+func (w *Workspace) Sync(ctx context.Context, target string) (string, error) {
+    env, cleanup, err := w.Credentials.environment()
+    if err != nil {
+        return "", &WorkspaceError{ Step: StepCredentials, Directory: w.Directory, Err: err }
+    }
+    defer cleanup()
+    w.env = env
+
+    if !w.isCheckout(ctx) {
+        if err := w.clone(ctx); err != nil {
+            return "", err
+        }
+    }
+    sha, err := w.update(ctx, target)
+    if err != nil {
+        // revisions that do not exist cannot be fixed by cloning again
+        if workspaceErr, ok := err.(*WorkspaceError); ok && workspaceErr.Step == StepResolve {
+            return "", err
+        }
+        log.Warn(fmt.Sprintf("unable to update workspace %s: %v. cloning repo again", w.Directory, err))
+        if err := w.clone(ctx); err != nil {
+            return "", err
+        }
+        return w.update(ctx, target)
+    }
+    return sha, nil
+}
+
+// function used to determine if workspace directory contains a valid
+// checkout of the workspace repo
+func (w *Workspace) isCheckout(ctx context.Context) bool {
+    if _, err := os.Stat(w.Directory); err != nil {
+        return false
+    }
+    output, err := w.git(ctx, "rev-parse", "--show-toplevel")
+    if err != nil {
+        log.Warn(fmt.Sprintf("directory %s is not a valid git checkout: %s", w.Directory, output))
+        return false
+    }
+    // nested directories of other checkouts are not valid workspaces
+    if !sameDirectory(strings.TrimSpace(output), w.Directory) {
+        log.Warn(fmt.Sprintf("directory %s is nested inside checkout %s", w.Directory, strings.TrimSpace(output)))
+        return false
+    }
+    remote, err := w.git(ctx, "remote", "get-url", "origin")
+    if err != nil || strings.TrimSpace(remote) != w.cloneUrl() {
+        log.Warn(fmt.Sprintf("directory %s has unexpected remote %s", w.Directory, strings.TrimSpace(remote)))
+        return false
+    }
+    return true
+}
+
+// function used to clone repo into workspace. existing checkouts are
+// removed before cloning. Note that directories that contain files
+// other than git checkouts are never removed to avoid data loss
+func (w *Workspace) clone(ctx context.Context) error {
+    log.Info(fmt.Sprintf("cloning git repo %s into directory %s", w.RepoUrl, w.Directory))
+    if _, err := os.Stat(w.Directory); err == nil {
+        if _, err := os.Stat(filepath.Join(w.Directory, ".git")); err != nil && !isEmptyDirectory(w.Directory) {
+            return &WorkspaceError{ Step: StepClone, Directory: w.Directory, Err: ExistingDirectoryError }
+        }
+        if err := os.RemoveAll(w.Directory); err != nil {
+            return &WorkspaceError{ Step: StepClone, Directory: w.Directory, Err: err }
+        }
+    }
+    cmd := exec.CommandContext(ctx, "git", "clone", "--no-checkout", w.cloneUrl(), w.Directory)
+    cmd.Env = append(os.Environ(), w.env...)
+    if output, err := runLogged(ctx, cmd); err != nil {
+        return &WorkspaceError{ Step: StepClone, Directory: w.Directory, Output: output, Err: err }
+    }
+    return nil
+}
+
+// function used to fetch from remote and force checkout of target revision
+func (w *Workspace) update(ctx context.Context, target string) (string, error) {
+    if output, err := w.git(ctx, "fetch", "--force", "--prune", "--tags", "origin", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+        return "", &WorkspaceError{ Step: StepFetch, Directory: w.Directory, Output: output, Err: err }
+    }
+    if len(target) == 0 {
+        // update default branch of remote in case it has changed since cloning
+        if output, err := w.git(ctx, "remote", "set-head", "origin", "--auto"); err != nil {
+            return "", &WorkspaceError{ Step: StepFetch, Directory: w.Directory, Output: output, Err: err }
+        }
+        target = "refs/remotes/origin/HEAD"
+    }
+
+    sha, err := w.git(ctx, "rev-parse", "--verify", "--quiet", target + "^{commit}")
+    if err != nil {
+        return "", &WorkspaceError{ Step: StepResolve, Directory: w.Directory, Output: fmt.Sprintf("unknown revision %s", target), Err: err }
+    }
+    sha = strings.TrimSpace(sha)
+
+    // checkout detached revision and discard any local changes. note that
+    // ignored files are kept since they commonly contain environment files
+    if output, err := w.git(ctx, "checkout", "--force", "--detach", sha); err != nil {
+        return "", &WorkspaceError{ Step: StepCheckout, Directory: w.Directory, Output: output, Err: err }
+    }
+    if output, err := w.git(ctx, "clean", "--force", "-d"); err != nil {
+        return "", &WorkspaceError{ Step: StepClean, Directory: w.Directory, Output: output, Err: err }
+    }
+    log.Info(fmt.Sprintf("checked out %s at commit %s in directory %s", target, sha, w.Directory))
+    return sha, nil
+}
+
+// helper function used to generate URL that repo is cloned from.
+// repos are cloned over SSH if a deploy key is used
+func (w *Workspace) cloneUrl() string {
+    if w.Credentials != nil && w.Credentials.CredentialType == CredentialDeployKey {
+        return sshCloneUrl(w.RepoUrl)
+    }
+    return w.RepoUrl + ".git"
+}
+
+// helper function used to run git command in workspace directory
+func (w *Workspace) git(ctx context.Context, args ...string) (string, error) {
+    cmd := exec.CommandContext(ctx, "git", args...)
+    cmd.Dir = w.Directory
+    cmd.Env = append(os.Environ(), w.env...)
+    return runLogged(ctx, cmd)
+}
+
+// helper function used to check if two paths point to the same directory
+func sameDirectory(a, b string) bool {
+    infoA, err := os.Stat(a)
+    if err != nil {
+        return false
+    }
+    infoB, err := os.Stat(b)
+    if err != nil {
+        return false
+    }
+    return os.SameFile(infoA, infoB)
+}
+
+// helper function used to remove file
+func removeFile(path string) error {
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// helper function used to check if directory is empty
+func isEmptyDirectory(directory string) bool {
+    entries, err := ioutil.ReadDir(directory)
+    return err == nil && len(entries) == 0
+}
@@ -17,7 +17,7 @@ var (
 )
 
 func ParseEvent(payload []byte) (*Event, error) {
-    log.Info(fmt.Sprintf("received event payload %s", payload))
+    log.Debug(fmt.Sprintf("received event payload %s", payload))
     parser := DefaultParser{}
     return parser.ParseEvent(payload)
 }
@@ -32,37 +32,111 @@ type Event struct {
 }
 
 type GitPushEvent struct {
+    EntryId              uuid.UUID `json:"entry_id"`
+    DeploymentId         uuid.UUID `json:"deployment_id"`
     RepoUrl	             string `json:"repo_url" validate:"required"`
     ApplicationDirectory string `json:"application_directory" validate:"required"`
+    Tag                  string `json:"tag,omitempty"`
+    Ref                  string `json:"ref,omitempty"`
+    CommitSha            string `json:"commit_sha,omitempty"`
+    Builder              string `json:"builder,omitempty"`
+    HealthCheckUrl       string `json:"health_check_url,omitempty"`
+    HealthCheckTimeout   int    `json:"health_check_timeout,omitempty"`
+    AutoRollback         bool   `json:"auto_rollback,omitempty"`
+    Pusher               string `json:"pusher,omitempty"`
+    HeadCommitMessage    string `json:"head_commit_message,omitempty"`
+    TriggeredAt          time.Time `json:"triggered_at"`
 }
 
 type NewGitRepoEvent struct {
+    EntryId              uuid.UUID `json:"entry_id"`
     RepoUrl 			 string	`json:"repo_url" validate:"required"`
     ApplicationDirectory string `json:"application_directory" validate:"required"`
 }
 
+type RemoveGitRepoEvent struct {
+    EntryId              uuid.UUID `json:"entry_id"`
+    RepoUrl 			 string	`json:"repo_url" validate:"required"`
+    ApplicationDirectory string `json:"application_directory" validate:"required"`
+    Builder              string `json:"builder,omitempty"`
+}
+
+type MoveGitRepoEvent struct {
+    EntryId                      uuid.UUID `json:"entry_id"`
+    RepoUrl 			         string	`json:"repo_url" validate:"required"`
+    PreviousApplicationDirectory string `json:"previous_application_directory" validate:"required"`
+    ApplicationDirectory         string `json:"application_directory" validate:"required"`
+    Builder                      string `json:"builder,omitempty"`
+}
+
+type RollbackEvent struct {
+    EntryId              uuid.UUID `json:"entry_id" validate:"required"`
+    DeploymentId         uuid.UUID `json:"deployment_id"`
+    RepoUrl              string    `json:"repo_url" validate:"required"`
+    ApplicationDirectory string    `json:"application_directory" validate:"required"`
+    CommitSha            string    `json:"commit_sha,omitempty"`
+    Builder              string    `json:"builder,omitempty"`
+    HealthCheckUrl       string    `json:"health_check_url,omitempty"`
+    HealthCheckTimeout   int       `json:"health_check_timeout,omitempty"`
+}
+
 type BuildTriggeredEvent struct {
-    EntryId uuid.UUID `json:"entry_id" validate:"required"`
-    RepoUrl string	  `json:"repo_url" validate:"required"`
+    EntryId      uuid.UUID `json:"entry_id" validate:"required"`
+    DeploymentId uuid.UUID `json:"deployment_id"`
+    RepoUrl      string	   `json:"repo_url" validate:"required"`
+    CommitSha    string    `json:"commit_sha"`
 }
 
 type BuildFailedEvent struct {
-    EntryId uuid.UUID `json:"entry_id" validate:"required"`
-    RepoUrl string	  `json:"repo_url" validate:"required"`
+    EntryId      uuid.UUID `json:"entry_id" validate:"required"`
+    DeploymentId uuid.UUID `json:"deployment_id"`
+    RepoUrl      string	   `json:"repo_url" validate:"required"`
+    CommitSha    string    `json:"commit_sha"`
+    Error        string    `json:"error"`
+    FailedStep   string    `json:"failed_step"`
+    DurationMs   int64     `json:"duration_ms"`
 }
 
 type BuildCompletedEvent struct {
-    EntryId     uuid.UUID `json:"entry_id" validate:"required"`
-    RepoUrl     string	  `json:"repo_url" validate:"required"`
-    ContainerId string    `json:"container_id" validate:"required"`
+    EntryId      uuid.UUID `json:"entry_id" validate:"required"`
+    DeploymentId uuid.UUID `json:"deployment_id"`
+    RepoUrl      string	   `json:"repo_url" validate:"required"`
+    CommitSha    string    `json:"commit_sha"`
+    ContainerIds []string  `json:"container_ids"`
+    DurationMs   int64     `json:"duration_ms"`
+    Skipped      bool      `json:"skipped"`
+}
+
+type BuildLogLine struct {
+    Sequence  int64     `json:"sequence" validate:"required"`
+    Timestamp time.Time `json:"timestamp" validate:"required"`
+    Stream    string    `json:"stream" validate:"required"`
+    Line      string    `json:"line"`
+}
+
+type BuildLogEvent struct {
+    EntryId      uuid.UUID      `json:"entry_id" validate:"required"`
+    DeploymentId uuid.UUID      `json:"deployment_id" validate:"required"`
+    Lines        []BuildLogLine `json:"lines" validate:"required,dive"`
 }
 
 type ContainerCrashedEvent struct {
-    ContainerId string `json:"container_id" validate:"required"`
+    EntryId           uuid.UUID `json:"entry_id" validate:"required"`
+    ContainerId       string    `json:"container_id" validate:"required"`
+    ContainerName     string    `json:"container_name"`
+    Release           string    `json:"release"`
+    ExitCode          int       `json:"exit_code"`
+    RestartsExhausted bool      `json:"restarts_exhausted"`
 }
 
 type ContainerRestartEvent struct {
-    ContainerId string `json:"container_id" validate:"required"`
+    EntryId       uuid.UUID `json:"entry_id" validate:"required"`
+    ContainerId   string    `json:"container_id" validate:"required"`
+    ContainerName string    `json:"container_name"`
+    ExitCode      int       `json:"exit_code"`
+    Attempt       int       `json:"attempt"`
+    Initiator     string    `json:"initiator" validate:"required,oneof=daemon docker"`
+    Error         string    `json:"error,omitempty"`
 }
 
 // #######################################
@@ -88,7 +162,7 @@ func(parser DefaultParser) ParseEvent(payload []byte) (*Event, error) {
         return &Event{}, InvalidEventError
     }
 
-    log.Info(fmt.Sprintf("parsing event type '%s' with payload '%s'", e.EventType, e.EventPayload))
+    log.Debug(fmt.Sprintf("parsing event type '%s' with payload '%s'", e.EventType, e.EventPayload))
     var event interface{}
 
     // parse original payload back to JSON format to parse manually
@@ -99,12 +173,20 @@ func(parser DefaultParser) ParseEvent(payload []byte) (*Event, error) {
         event, err= parser.ParseNewGitRepoEvent(eventPayload)
     case "GitPushEvent":
         event, err = parser.ParseGitPushEvent(eventPayload)
+    case "RemoveGitRepoEvent":
+        event, err = parser.ParseRemoveGitRepoEvent(eventPayload)
+    case "MoveGitRepoEvent":
+        event, err = parser.ParseMoveGitRepoEvent(eventPayload)
+    case "RollbackEvent":
+        event, err = parser.ParseRollbackEvent(eventPayload)
     case "BuildTriggeredEvent":
         event, err = parser.ParseBuildTriggeredEvent(eventPayload)
     case "BuildFailedEvent":
         event, err = parser.ParseBuildFailedEvent(eventPayload)
     case "BuildCompletedEvent":
         event, err = parser.ParseBuildCompletedEvent(eventPayload)
+    case "BuildLogEvent":
+        event, err = parser.ParseBuildLogEvent(eventPayload)
     case "ContainerCrashedEvent":
         event, err = parser.ParseContainerCrashedEvent(eventPayload)
     case "ContainerRestartEvent":
@@ -119,7 +201,7 @@ func(parser DefaultParser) ParseEvent(payload []byte) (*Event, error) {
     }
 
     // assign parsed event payload as attribute of event
-    log.Info(fmt.Sprintf("successfully parsed event %+v", event))
+    log.Debug(fmt.Sprintf("successfully parsed event %+v", event))
     e.EventPayload = event
     return &e, validate.Struct(event)
 }
@@ -130,6 +212,12 @@ func(parser DefaultParser) ParseGitPushEvent(eventPayload []byte) (GitPushEvent,
     return event, err
 }
 
+func(parser DefaultParser) ParseRollbackEvent(eventPayload []byte) (RollbackEvent, error) {
+    var event RollbackEvent
+    err := json.Unmarshal(eventPayload, &event)
+    return event, err
+}
+
 func(parser DefaultParser) ParseBuildTriggeredEvent(eventPayload []byte) (BuildTriggeredEvent, error) {
     var event BuildTriggeredEvent
     err := json.Unmarshal(eventPayload, &event)
@@ -148,6 +236,12 @@ func(parser DefaultParser) ParseBuildCompletedEvent(eventPayload []byte) (BuildC
     return event, err
 }
 
+func(parser DefaultParser) ParseBuildLogEvent(eventPayload []byte) (BuildLogEvent, error) {
+    var event BuildLogEvent
+    err := json.Unmarshal(eventPayload, &event)
+    return event, err
+}
+
 func(parser DefaultParser) ParseContainerCrashedEvent(eventPayload []byte) (ContainerCrashedEvent, error) {
     var event ContainerCrashedEvent
     err := json.Unmarshal(eventPayload, &event)
@@ -164,4 +258,16 @@ func(parser DefaultParser) ParseNewGitRepoEvent(eventPayload []byte) (NewGitRepo
     var event NewGitRepoEvent
     err := json.Unmarshal(eventPayload, &event)
     return event, err
+}
+
+func(parser DefaultParser) ParseRemoveGitRepoEvent(eventPayload []byte) (RemoveGitRepoEvent, error) {
+    var event RemoveGitRepoEvent
+    err := json.Unmarshal(eventPayload, &event)
+    return event, err
+}
+
+func(parser DefaultParser) ParseMoveGitRepoEvent(eventPayload []byte) (MoveGitRepoEvent, error) {
+    var event MoveGitRepoEvent
+    err := json.Unmarshal(eventPayload, &event)
+    return event, err
 }
\ No newline at end of file